@@ -0,0 +1,165 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lithdew/casso"
+	"io"
+)
+
+var ErrAssertion = errors.New("assertion failed")
+
+// layoutTolerance absorbs solver rounding when comparing edges
+const layoutTolerance = 1e-6
+
+// assertions are the builtins for checking things in test blocks (or anywhere
+// else), a failing one raises a RuntimeError wrapping ErrAssertion
+var assertions = map[string]NativeFunctionData{
+	"assert": {Name: "assert", Arity: -1, Fn: func(args []Data) (Data, error) {
+		// assert(cond) or assert(cond, message)
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("expects 1 or 2 args got %d", len(args))
+		}
+		cond, ok := args[0].(BooleanData)
+		if !ok {
+			return nil, fmt.Errorf("condition must be BooleanData got %s", args[0])
+		}
+
+		if !cond.Value {
+			if len(args) == 2 {
+				return nil, fmt.Errorf("%w: %s", ErrAssertion, Format(args[1]))
+			}
+			return nil, ErrAssertion
+		}
+		return NoData{}, nil
+	}},
+	"assert_eq": {Name: "assert_eq", Arity: 2, Fn: func(args []Data) (Data, error) {
+		if !Equal(args[0], args[1]) {
+			return nil, fmt.Errorf("%w: %s != %s", ErrAssertion, formatNested(args[0]), formatNested(args[1]))
+		}
+		return NoData{}, nil
+	}},
+	"assert_left_of":  layoutAssertion("assert_left_of", "left of", func(a, b LayoutItem) bool { return a.RightEdge() <= b.LeftEdge()+layoutTolerance }),
+	"assert_right_of": layoutAssertion("assert_right_of", "right of", func(a, b LayoutItem) bool { return a.LeftEdge() >= b.RightEdge()-layoutTolerance }),
+	"assert_above":    layoutAssertion("assert_above", "above", func(a, b LayoutItem) bool { return a.Bottom() <= b.Top()+layoutTolerance }),
+	"assert_below":    layoutAssertion("assert_below", "below", func(a, b LayoutItem) bool { return a.Top() >= b.Bottom()-layoutTolerance }),
+}
+
+// layoutAssertion checks holds(a, b) for two layout items after solving
+func layoutAssertion(name string, relation string, holds func(a, b LayoutItem) bool) NativeFunctionData {
+	return NativeFunctionData{Name: name, Arity: 2, Fn: func(args []Data) (Data, error) {
+		a, ok := args[0].(LayoutItem)
+		if !ok {
+			return nil, fmt.Errorf("argument 1 must be a layout item got %s", args[0])
+		}
+		b, ok := args[1].(LayoutItem)
+		if !ok {
+			return nil, fmt.Errorf("argument 2 must be a layout item got %s", args[1])
+		}
+
+		if !holds(a, b) {
+			return nil, fmt.Errorf("%w: %s is not %s %s", ErrAssertion, a, relation, b)
+		}
+		return NoData{}, nil
+	}}
+}
+
+// TestBlock is `test "name" { ... }`, normally it does nothing, when the
+// runtime is collecting tests (see CollectTests) it's saved to be run later
+type TestBlock struct {
+	Name string
+	Body Block
+}
+
+func NewTestBlock(name string, body Block) TestBlock {
+	return TestBlock{Name: NewStringLiteral(name).Value, Body: body}
+}
+
+func (tb TestBlock) String() string {
+	return fmt.Sprintf("test %q {\n%s\n}", tb.Name, tb.Body)
+}
+
+func (tb TestBlock) Eval(r Runtime) Data {
+	if r.tests != nil {
+		*r.tests = append(*r.tests, tb)
+	}
+
+	return NoData{}
+}
+
+type TestResult struct {
+	Name string
+	Err  error // nil if the test passed
+}
+
+func (tr TestResult) Passed() bool { return tr.Err == nil }
+
+// CollectTests returns a runtime that saves the test blocks it evaluates for RunTests
+func (r Runtime) CollectTests() Runtime {
+	r.tests = &[]TestBlock{}
+	return r
+}
+
+// RunTests runs every collected test in a fresh runtime where program has
+// been run again, so each test gets its own variables, solver and modules and
+// nothing a test (or the program before it) does can affect another test
+func (r Runtime) RunTests(program Expression) []TestResult {
+	if r.tests == nil {
+		return nil
+	}
+
+	var results []TestResult
+	for _, test := range *r.tests {
+		testRuntime, err := r.testRuntime(program)
+		if err == nil {
+			err = test.run(testRuntime)
+		}
+		results = append(results, TestResult{Name: test.Name, Err: err})
+	}
+
+	return results
+}
+
+// testRuntime is r with nothing shared with it, after running program in it
+// quietly: its output and hook are put back once program has run
+func (r Runtime) testRuntime(program Expression) (Runtime, error) {
+	solver := casso.NewSolver()
+	fresh := r
+	fresh.SymbolTable = map[string]Data{}
+	fresh.Solver = solver
+	if r.Constraints != nil {
+		fresh.Constraints = NewConstraintTrace(solver)
+	}
+	if r.Modules != nil {
+		fresh.Modules = &ModuleLoader{Parse: r.Modules.Parse, SearchPath: r.Modules.SearchPath, cache: map[string]ModuleData{}}
+	}
+	fresh.calls = &callStack{}
+	fresh.tests = nil
+
+	setup := fresh
+	setup.Stdout = io.Discard
+	setup.Output = NewMemorySink()
+	setup.Hook = nil
+
+	return fresh, evalCaught(setup, program)
+}
+
+func (tb TestBlock) run(r Runtime) error {
+	return evalCaught(r, tb.Body)
+}
+
+// evalCaught evaluates e, returning the runtime error it raised
+func evalCaught(r Runtime, e Expression) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			runtimeErr, ok := recovered.(RuntimeError)
+			if !ok {
+				panic(recovered)
+			}
+			err = runtimeErr
+		}
+	}()
+
+	eval(r, e)
+	return nil
+}
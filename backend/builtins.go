@@ -0,0 +1,235 @@
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxStringLength is the longest string a builtin will build, so one call
+// can't use up the host's memory
+const maxStringLength = 1 << 24
+
+// builtins are the native functions NewRuntime registers, a variable with
+// the same name shadows them
+var builtins = map[string]NativeFunctionData{
+	"len": {Name: "len", Arity: 1, Fn: func(args []Data) (Data, error) {
+		switch arg := args[0].(type) {
+		case StringData:
+			return intData(len([]rune(arg.Value))), nil
+		case ListData:
+			return intData(len(arg.Values)), nil
+		case MapData:
+			return intData(len(arg.Keys)), nil
+		}
+		return nil, fmt.Errorf("expects a string, list or map got %s", args[0])
+	}},
+	"substr": {Name: "substr", Arity: 3, Fn: func(args []Data) (Data, error) {
+		// substr(s, start, stop) is s[start:stop]
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		runes := []rune(s)
+		start := normalizeIndex(args[1], len(runes), "substr", 1)
+		stop := normalizeIndex(args[2], len(runes), "substr", 1)
+		if start > stop {
+			return nil, fmt.Errorf("start %d is after stop %d", start, stop)
+		}
+
+		return stringData(string(runes[start:stop])), nil
+	}},
+	"split": {Name: "split", Arity: 2, Fn: func(args []Data) (Data, error) {
+		s, sep, err := stringArgs(args)
+		if err != nil {
+			return nil, err
+		}
+
+		var parts ListData
+		for _, part := range strings.Split(s, sep) {
+			parts.Values = append(parts.Values, stringData(part))
+		}
+		return parts, nil
+	}},
+	"join": {Name: "join", Arity: 2, Fn: func(args []Data) (Data, error) {
+		list, ok := args[0].(ListData)
+		if !ok {
+			return nil, fmt.Errorf("argument 1 must be ListData got %s", args[0])
+		}
+		sep, err := stringArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		var parts []string
+		for _, value := range list.Values {
+			parts = append(parts, Format(value))
+		}
+		return stringData(strings.Join(parts, sep)), nil
+	}},
+	"upper": stringFunction("upper", strings.ToUpper),
+	"lower": stringFunction("lower", strings.ToLower),
+	"trim":  stringFunction("trim", strings.TrimSpace),
+	"contains": {Name: "contains", Arity: 2, Fn: func(args []Data) (Data, error) {
+		s, substr, err := stringArgs(args)
+		if err != nil {
+			return nil, err
+		}
+
+		found := strings.Contains(s, substr)
+		return BooleanData{Value: found, Literal: fmt.Sprintf("%t", found)}, nil
+	}},
+	"replace": {Name: "replace", Arity: 3, Fn: func(args []Data) (Data, error) {
+		s, old, err := stringArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		replacement, err := stringArg(args, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		return stringData(strings.ReplaceAll(s, old, replacement)), nil
+	}},
+	"repeat": {Name: "repeat", Arity: 2, Fn: func(args []Data) (Data, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		count, ok := args[1].(IntData)
+		if !ok || count.Value < 0 {
+			return nil, fmt.Errorf("count must be a non negative IntData got %s", args[1])
+		}
+		// len(s) * count could overflow, so it's divided instead
+		if count.Value > 0 && len(s) > maxStringLength/count.Value {
+			return nil, fmt.Errorf("%d copies of a %d byte string would be longer than %d bytes", count.Value, len(s), maxStringLength)
+		}
+
+		return stringData(strings.Repeat(s, count.Value)), nil
+	}},
+	"int": {Name: "int", Arity: 1, Fn: func(args []Data) (Data, error) {
+		switch arg := args[0].(type) {
+		case IntData:
+			return arg, nil
+		case StringData:
+			value, err := strconv.Atoi(strings.TrimSpace(arg.Value))
+			if err != nil {
+				return nil, fmt.Errorf("can't convert %q to an int", arg.Value)
+			}
+			return intData(value), nil
+		case BooleanData:
+			if arg.Value {
+				return intData(1), nil
+			}
+			return intData(0), nil
+		}
+		return nil, fmt.Errorf("can't convert %s to an int", args[0])
+	}},
+	"str": {Name: "str", Arity: 1, Fn: func(args []Data) (Data, error) {
+		return stringData(Format(args[0])), nil
+	}},
+	"format": {Name: "format", Arity: -1, Fn: func(args []Data) (Data, error) {
+		// format("{} x {}", w, h), each {} is replaced by the next argument
+		if len(args) == 0 {
+			return nil, fmt.Errorf("expects a format string")
+		}
+		template, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		values := args[1:]
+		var sb strings.Builder
+		for {
+			before, after, found := strings.Cut(template, "{}")
+			sb.WriteString(before)
+			if !found {
+				break
+			}
+			if len(values) == 0 {
+				return nil, fmt.Errorf("not enough arguments for %q", args[0].(StringData).Value)
+			}
+			sb.WriteString(Format(values[0]))
+			values = values[1:]
+			template = after
+		}
+		if len(values) > 0 {
+			return nil, fmt.Errorf("too many arguments for %q", args[0].(StringData).Value)
+		}
+
+		return stringData(sb.String()), nil
+	}},
+	"explain": {Name: "explain", Arity: 1, Fn: func(args []Data) (Data, error) {
+		// explain(box) is where the box is and the constraints that put it there
+		item, ok := args[0].(LayoutItem)
+		if !ok {
+			return nil, fmt.Errorf("expects a layout item got %s", args[0])
+		}
+		trace := traceOf(item)
+		if trace == nil {
+			return nil, fmt.Errorf("no constraints were recorded for %s", item)
+		}
+		return stringData(trace.ExplainString(item)), nil
+	}},
+	"span": {Name: "span", Arity: 3, Fn: func(args []Data) (Data, error) {
+		// span(item, columns, rows) takes up columns x rows cells in a grid
+		item, ok := args[0].(LayoutItem)
+		if !ok {
+			return nil, fmt.Errorf("expects a layout item got %s", args[0])
+		}
+		columns, ok := args[1].(IntData)
+		if !ok {
+			return nil, fmt.Errorf("argument 2 must be IntData got %s", args[1])
+		}
+		rows, ok := args[2].(IntData)
+		if !ok {
+			return nil, fmt.Errorf("argument 3 must be IntData got %s", args[2])
+		}
+		if columns.Value < 1 || rows.Value < 1 || columns.Value > maxGridTracks || rows.Value > maxGridTracks {
+			return nil, fmt.Errorf("spans 1 to %d columns and rows got %dx%d", maxGridTracks, columns.Value, rows.Value)
+		}
+
+		return Span{LayoutItem: item, Columns: columns.Value, Rows: rows.Value}, nil
+	}},
+}
+
+// stringFunction wraps a go string -> string function as a builtin
+func stringFunction(name string, fn func(string) string) NativeFunctionData {
+	return NativeFunctionData{Name: name, Arity: 1, Fn: func(args []Data) (Data, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return stringData(fn(s)), nil
+	}}
+}
+
+func stringArg(args []Data, i int) (string, error) {
+	s, ok := args[i].(StringData)
+	if !ok {
+		return "", fmt.Errorf("argument %d must be StringData got %s", i+1, args[i])
+	}
+	return s.Value, nil
+}
+
+// stringArgs is the first two args as strings
+func stringArgs(args []Data) (string, string, error) {
+	first, err := stringArg(args, 0)
+	if err != nil {
+		return "", "", err
+	}
+	second, err := stringArg(args, 1)
+	if err != nil {
+		return "", "", err
+	}
+	return first, second, nil
+}
+
+func stringData(s string) StringData {
+	return StringData{Value: s, Literal: strconv.Quote(s)}
+}
+
+func intData(i int) IntData {
+	return IntData{Value: i, Literal: strconv.Itoa(i)}
+}
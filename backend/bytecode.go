@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+type opcode byte
+
+// in the comments a is the instruction's first operand and b its second,
+// "pop x" means x is taken off the top of the stack
+const (
+	opConst     opcode = iota // push constants[a]
+	opPop                     // pop and discard
+	opLoad                    // push variable names[a], from slot b if b >= 0
+	opStore                   // pop value into variable names[a] (slot b if b >= 0)
+	opDelete                  // remove variable names[a] (slot b if b >= 0)
+	opFunc                    // push the function names[a], erroring if it doesn't exist
+	opCall                    // pop a args and the function under them, push the result, b is the call node
+	opTailCall                // opCall as the last thing a function does, calls to functions return from the chunk instead
+	opArith                   // pop right, left, push nodes[a].(Arithmetic) applied to them
+	opNegate                  // pop value, push nodes[a].(Negate) applied to it
+	opNot                     // pop value, push nodes[a].(Not) applied to it
+	opConcat                  // pop right, left, push nodes[a].(Concat) applied to them
+	opCompare                 // pop right, left, push nodes[a].(Compare) applied to them
+	opLogical                 // check the top is a boolean for and/or nodes[a], b is 0 for the left side 1 for the right
+	opJump                    // continue at a
+	opJumpIf                  // if the top is the boolean b != 0 leave it and continue at a, otherwise pop it
+	opJumpFalse               // pop a boolean, continue at a if it's false, constants[b] is the error if it isn't a boolean
+	opListSize                // check a list of a elements is allowed
+	opList                    // pop a values, push them as a list
+	opMapKey                  // check the top is a valid map key
+	opMap                     // pop a key value pairs, push them as a map
+	opMethod                  // pop b args and the receiver, push nodes[a].(MethodCall) applied to them
+	opField                   // pop receiver, push nodes[a].(FieldAccess) applied to it
+	opIndex                   // pop position, target, push nodes[a].(Index) applied to them
+	opPrint                   // pop b values and print them with nodes[a].(Print)
+	opRange                   // pop step, stop, start, checked by nodes[a].(Loop), and push an iterator over them
+	opIter                    // pop a list or map and push an iterator over it
+	opNext                    // push the iterator's next value, or pop it and continue at a if it's done
+	opEval                    // push nodes[a] evaluated by the tree walking interpreter
+)
+
+var opcodeNames = map[opcode]string{
+	opConst: "CONST", opPop: "POP", opLoad: "LOAD", opStore: "STORE", opDelete: "DELETE",
+	opFunc: "FUNC", opCall: "CALL", opTailCall: "TAIL_CALL", opArith: "ARITH", opNegate: "NEGATE", opNot: "NOT",
+	opConcat: "CONCAT", opCompare: "COMPARE", opLogical: "LOGICAL", opJump: "JUMP",
+	opJumpIf: "JUMP_IF", opJumpFalse: "JUMP_FALSE", opListSize: "LIST_SIZE", opList: "LIST",
+	opMapKey: "MAP_KEY", opMap: "MAP", opMethod: "METHOD", opField: "FIELD", opIndex: "INDEX",
+	opPrint: "PRINT", opRange: "RANGE", opIter: "ITER", opNext: "NEXT", opEval: "EVAL",
+}
+
+type instruction struct {
+	op   opcode
+	a, b int
+}
+
+// Chunk is compiled bytecode for a program or function body
+type Chunk struct {
+	Name      string
+	code      []instruction
+	constants []Data
+	names     []string
+	nodes     []Expression // ast nodes instructions need for messages or fallback
+	// slots are the variables of a function with parameters, resolved to
+	// indexes at compile time. parameters come first
+	slots     []string
+	slotIndex map[string]int
+	functions []*Chunk // compiled bodies of functions declared inside, for String
+}
+
+func (c *Chunk) emit(op opcode, a, b int) int {
+	c.code = append(c.code, instruction{op: op, a: a, b: b})
+	return len(c.code) - 1
+}
+
+func (c *Chunk) constant(data Data) int {
+	c.constants = append(c.constants, data)
+	return len(c.constants) - 1
+}
+
+func (c *Chunk) name(name string) int {
+	for i, existing := range c.names {
+		if existing == name {
+			return i
+		}
+	}
+
+	c.names = append(c.names, name)
+	return len(c.names) - 1
+}
+
+func (c *Chunk) node(e Expression) int {
+	c.nodes = append(c.nodes, e)
+	return len(c.nodes) - 1
+}
+
+// slot is the slot of a variable, -1 if it's looked up by name
+func (c *Chunk) slot(name string) int {
+	if i, ok := c.slotIndex[name]; ok {
+		return i
+	}
+	return -1
+}
+
+// String disassembles the chunk and the functions declared in it
+func (c *Chunk) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("== %s", c.Name))
+	if len(c.slots) > 0 {
+		sb.WriteString(fmt.Sprintf(" slots %s", strings.Join(c.slots, ", ")))
+	}
+	sb.WriteString(" ==\n")
+
+	for i, in := range c.code {
+		sb.WriteString(fmt.Sprintf("%04d %-10s", i, opcodeNames[in.op]))
+		switch in.op {
+		case opConst:
+			if function, ok := c.constants[in.a].(FunctionData); ok {
+				sb.WriteString(" " + Format(function))
+			} else {
+				sb.WriteString(fmt.Sprintf(" %v", c.constants[in.a]))
+			}
+		case opLoad, opStore, opDelete, opFunc:
+			sb.WriteString(fmt.Sprintf(" %s", c.names[in.a]))
+			if in.b >= 0 && in.op != opFunc {
+				sb.WriteString(fmt.Sprintf(" (slot %d)", in.b))
+			}
+		case opEval, opArith, opNegate, opNot, opConcat, opCompare, opField, opIndex:
+			sb.WriteString(fmt.Sprintf(" `%s`", c.nodes[in.a]))
+		default:
+			sb.WriteString(fmt.Sprintf(" %d %d", in.a, in.b))
+		}
+		sb.WriteString("\n")
+	}
+
+	for _, function := range c.functions {
+		sb.WriteString(function.String())
+	}
+
+	return sb.String()
+}
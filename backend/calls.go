@@ -0,0 +1,225 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxCallDepth is how deep NewRuntime lets functions recurse before
+// raising ErrCallDepth, well before go's own stack would overflow
+const DefaultMaxCallDepth = 10000
+
+// StackFrame is a function that was running when a RuntimeError was raised
+type StackFrame struct {
+	Function string
+	// File, Line and Column are where it was called from, Line is 0 when it
+	// was called by a method like list.map rather than from source
+	File         string
+	Line, Column int
+	Args         []Data
+}
+
+// maxArgLength is how much of each argument a StackFrame shows
+const maxArgLength = 40
+
+func (sf StackFrame) String() string {
+	var args []string
+	for _, arg := range sf.Args {
+		formatted := formatNested(arg)
+		if len(formatted) > maxArgLength {
+			formatted = formatted[:maxArgLength-3] + "..."
+		}
+		args = append(args, formatted)
+	}
+
+	frame := fmt.Sprintf("%s(%s)", sf.Function, strings.Join(args, ", "))
+	if sf.Line > 0 {
+		frame += fmt.Sprintf(" at line %d:%d", sf.Line, sf.Column)
+	}
+	return frame
+}
+
+// site is true if both frames are calls to the same function from the same place
+func (sf StackFrame) site(other StackFrame) bool {
+	return sf.Function == other.Function && sf.File == other.File && sf.Line == other.Line && sf.Column == other.Column
+}
+
+// callStack is the functions currently running, it's shared by every scope of a runtime
+type callStack struct {
+	frames []StackFrame
+}
+
+// enter pushes a call, raising if it goes deeper than the runtime allows
+func (cs *callStack) enter(r Runtime, frame StackFrame) {
+	if cs == nil {
+		return
+	}
+
+	cs.frames = append(cs.frames, frame)
+	if r.MaxCallDepth > 0 && len(cs.frames) > r.MaxCallDepth {
+		stack := cs.trace()
+		cs.frames = cs.frames[:len(cs.frames)-1] // enter panicking means the caller never defers leave
+
+		panic(RuntimeError{
+			Message: fmt.Sprintf("%s: calling %s deeper than %d", ErrCallDepth, frame.Function, r.MaxCallDepth),
+			Err:     ErrCallDepth,
+			Stack:   stack,
+		})
+	}
+
+	if hook, ok := r.Hook.(CallHook); ok {
+		hook.Enter(r, frame)
+	}
+}
+
+func (cs *callStack) leave(r Runtime) {
+	if cs == nil {
+		return
+	}
+
+	cs.frames = cs.frames[:len(cs.frames)-1]
+	if hook, ok := r.Hook.(CallHook); ok {
+		hook.Leave(r)
+	}
+}
+
+// replace swaps the innermost call for a tail call it made
+func (cs *callStack) replace(r Runtime, frame StackFrame) {
+	if cs == nil {
+		return
+	}
+
+	cs.frames[len(cs.frames)-1] = frame
+	if hook, ok := r.Hook.(CallHook); ok {
+		hook.Leave(r)
+		hook.Enter(r, frame)
+	}
+}
+
+// annotate is deferred by calls, it gives a RuntimeError raised inside the
+// call the stack as it was when it was raised
+func (cs *callStack) annotate() {
+	if cs == nil {
+		return
+	}
+
+	if recovered := recover(); recovered != nil {
+		if runtimeErr, ok := recovered.(RuntimeError); ok && runtimeErr.Stack == nil {
+			runtimeErr.Stack = cs.trace()
+			panic(runtimeErr)
+		}
+		panic(recovered)
+	}
+}
+
+func (cs *callStack) depth() int {
+	if cs == nil {
+		return 0
+	}
+	return len(cs.frames)
+}
+
+// trace copies the stack innermost call first
+func (cs *callStack) trace() []StackFrame {
+	if cs == nil {
+		return nil
+	}
+
+	var stack []StackFrame
+	for i := len(cs.frames) - 1; i >= 0; i-- {
+		stack = append(stack, cs.frames[i])
+	}
+	return stack
+}
+
+// StackTrace renders the error's call stack, runs of calls from the same
+// place (deep recursion) are collapsed into one line
+func (re RuntimeError) StackTrace() string {
+	if len(re.Stack) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("call stack, most recent call first:\n")
+	for i := 0; i < len(re.Stack); {
+		repeats := 1
+		for i+repeats < len(re.Stack) && re.Stack[i+repeats].site(re.Stack[i]) {
+			repeats++
+		}
+
+		sb.WriteString(fmt.Sprintf("  in %s", re.Stack[i]))
+		if repeats > 1 {
+			sb.WriteString(fmt.Sprintf(" (%d times)", repeats))
+		}
+		sb.WriteString("\n")
+		i += repeats
+	}
+
+	return sb.String()
+}
+
+// tailCall is a call to a function made as the last thing another function
+// does, evalTail returns it instead of making it so call can run it in a
+// loop without growing the go stack
+type tailCall struct {
+	r        Runtime // where the call was made
+	frame    StackFrame
+	function FunctionData
+}
+
+func (tc tailCall) String() string { return fmt.Sprintf("tailCall:%s", tc.frame.Function) }
+
+// evalTail is evalAt for a function body, calls to functions in tail
+// position are returned as a tailCall instead of being made. Hooks see those
+// calls finish with a nil result when they're handed off
+func evalTail(r Runtime, e Expression, pos Position) Data {
+	if r.budget != nil {
+		r.budget.step()
+	}
+
+	if r.Hook == nil {
+		return tail(r, e)
+	}
+	if pos.Line > 0 {
+		pos.File = r.File
+	}
+
+	r.Hook.Before(r, e, pos)
+	result := tail(r, e)
+	if _, ok := result.(tailCall); ok {
+		r.Hook.After(r, e, pos, nil)
+	} else {
+		r.Hook.After(r, e, pos, result)
+	}
+
+	return result
+}
+
+func tail(r Runtime, e Expression) Data {
+	switch e := e.(type) {
+	case Block:
+		// Block.Eval's copy of the symbol table is never used, so it's skipped here
+		var last Data
+		for i, expr := range e.Exprs {
+			if i == len(e.Exprs)-1 {
+				return evalTail(r, expr, e.position(i))
+			}
+			last = evalAt(r, expr, e.position(i))
+		}
+		return last
+	case IfElifElse:
+		body, ok := e.branch(r)
+		if !ok {
+			return NoData{}
+		}
+		return evalTail(r, body, Position{})
+	case FunctionCall:
+		f, args := e.evalCallee(r)
+		if function, ok := f.(FunctionData); ok {
+			return tailCall{r: r, frame: e.frame(r, args), function: function}
+		}
+		return callFrame(r, e.frame(r, args), f)
+	default:
+		return e.Eval(r)
+	}
+}
@@ -0,0 +1,324 @@
+package backend
+
+import "fmt"
+
+// Compile turns a program into bytecode for RunCompiled, expressions the
+// compiler doesn't handle are left to the tree walking interpreter
+func Compile(program Expression) *Chunk {
+	chunk := &Chunk{Name: "program"}
+	chunk.compile(program)
+
+	return chunk
+}
+
+// compileFunction compiles a function body, functions with parameters get
+// a slot for every parameter and every variable they assign. ones without
+// run in their caller's scope (see call) so everything is looked up by name
+func compileFunction(name string, params []string, body Expression) *Chunk {
+	chunk := &Chunk{Name: name}
+
+	if len(params) > 0 {
+		chunk.slotIndex = map[string]int{}
+		for _, local := range append(append([]string{}, params...), assignedNames(body)...) {
+			if _, ok := chunk.slotIndex[local]; !ok {
+				chunk.slotIndex[local] = len(chunk.slots)
+				chunk.slots = append(chunk.slots, local)
+			}
+		}
+	}
+
+	chunk.compileTail(body)
+	return chunk
+}
+
+// compiledCache holds the code compiled for a function the tree walker
+// declared, it's shared by every copy of the function
+type compiledCache struct {
+	code *Chunk
+}
+
+// compiled is the function's code, functions the tree walker declared are
+// compiled the first time the vm calls them
+func (f FunctionData) compiled() *Chunk {
+	if f.code != nil {
+		return f.code
+	}
+	if f.cache == nil {
+		return compileFunction(f.Name, f.Args, f.Body)
+	}
+
+	if f.cache.code == nil {
+		f.cache.code = compileFunction(f.Name, f.Args, f.Body)
+	}
+	return f.cache.code
+}
+
+// assignedNames are the variables body can write to, not counting the
+// bodies of functions declared inside it
+func assignedNames(body Expression) []string {
+	var names []string
+
+	var visit func(e Expression)
+	visit = func(e Expression) {
+		switch e := e.(type) {
+		case Assign:
+			names = append(names, e.Name)
+		case Loop:
+			names = append(names, e.Iterator)
+		case ForEach:
+			names = append(names, e.Iterator)
+		case Declare:
+			names = append(names, e.Name)
+			return
+		case RecordDecl:
+			names = append(names, e.Name)
+		case Import:
+			names = append(names, e.Alias)
+		case MethodCall, IndexAssign, FieldAssign:
+			if root, ok := rootName(e); ok {
+				names = append(names, root)
+			}
+		}
+
+		for _, child := range children(e) {
+			visit(child)
+		}
+	}
+	visit(body)
+
+	return names
+}
+
+// rootName is the variable a write through an index, field or method ends up in
+func rootName(e Expression) (string, bool) {
+	switch e := e.(type) {
+	case Dereference:
+		return e.Name, true
+	case Index:
+		return rootName(e.Target)
+	case FieldAccess:
+		return rootName(e.Receiver)
+	case MethodCall:
+		return rootName(e.Receiver)
+	case IndexAssign:
+		return rootName(e.Target)
+	case FieldAssign:
+		return rootName(e.Receiver)
+	default:
+		return "", false
+	}
+}
+
+// compile emits code that leaves the value of e on the stack
+func (c *Chunk) compile(e Expression) {
+	switch e := e.(type) {
+	case IntLiteral:
+		c.emit(opConst, c.constant(e.IntData), 0)
+	case StringLiteral:
+		c.emit(opConst, c.constant(e.StringData), 0)
+	case BooleanLiteral:
+		c.emit(opConst, c.constant(e.BooleanData), 0)
+
+	case Assign:
+		c.compile(e.Expr)
+		c.emit(opStore, c.name(e.Name), c.slot(e.Name))
+		c.emit(opConst, c.constant(NoData{}), 0)
+
+	case Block:
+		if len(e.Exprs) == 0 {
+			c.emit(opConst, c.constant(nil), 0)
+		}
+		for i, expr := range e.Exprs {
+			c.compile(expr)
+			if i < len(e.Exprs)-1 {
+				c.emit(opPop, 0, 0)
+			}
+		}
+
+	case Dereference:
+		c.emit(opLoad, c.name(e.Name), c.slot(e.Name))
+
+	case Arithmetic:
+		c.compile(e.Left)
+		c.compile(e.Right)
+		c.emit(opArith, c.node(e), 0)
+	case Negate:
+		c.compile(e.Expr)
+		c.emit(opNegate, c.node(e), 0)
+	case Not:
+		c.compile(e.Expr)
+		c.emit(opNot, c.node(e), 0)
+	case Concat:
+		c.compile(e.Left)
+		c.compile(e.Right)
+		c.emit(opConcat, c.node(e), 0)
+
+	case Compare:
+		node := c.node(e)
+		c.compile(e.Left)
+		if e.Op != AND && e.Op != OR {
+			c.compile(e.Right)
+			c.emit(opCompare, node, 0)
+			break
+		}
+
+		// and/or short circuit
+		c.emit(opLogical, node, 0)
+		jumpOnOr := 0
+		if e.Op == OR {
+			jumpOnOr = 1
+		}
+		decided := c.emit(opJumpIf, 0, jumpOnOr)
+		c.compile(e.Right)
+		c.emit(opLogical, node, 1)
+		c.code[decided].a = len(c.code)
+
+	case Loop:
+		c.compile(e.Start)
+		c.compile(e.Stop)
+		c.compile(e.Step)
+		c.emit(opRange, c.node(e), 0)
+		c.compileLoop(e.Iterator, e.Body)
+	case ForEach:
+		c.compile(e.Iterable)
+		c.emit(opIter, 0, 0)
+		c.compileLoop(e.Iterator, e.Body)
+
+	case IfElifElse:
+		c.compileIf(e, c.compile)
+
+	case List:
+		c.emit(opListSize, len(e.Values), 0)
+		for _, value := range e.Values {
+			c.compile(value)
+		}
+		c.emit(opList, len(e.Values), 0)
+
+	case Map:
+		for i := range e.Keys {
+			c.compile(e.Keys[i])
+			c.emit(opMapKey, 0, 0)
+			c.compile(e.Values[i])
+		}
+		c.emit(opMap, len(e.Keys), 0)
+
+	case FunctionCall:
+		c.emit(opFunc, c.name(e.Name), 0)
+		for _, arg := range e.Args {
+			c.compile(arg)
+		}
+		c.emit(opCall, len(e.Args), c.node(e))
+
+	case Declare:
+		function := compileFunction(e.Name, e.Args, e.Body)
+		c.functions = append(c.functions, function)
+
+		c.emit(opConst, c.constant(FunctionData{Name: e.Name, Args: e.Args, Body: e.Body, code: function}), 0)
+		c.emit(opStore, c.name(e.Name), c.slot(e.Name))
+		c.emit(opConst, c.constant(NoData{}), 0)
+
+	case Print:
+		for _, arg := range e.Args {
+			c.compile(arg)
+		}
+		c.emit(opPrint, c.node(e), len(e.Args))
+
+	case MethodCall:
+		// writing back into an index or field is left to the interpreter
+		if _, ok := e.Receiver.(Dereference); !ok && assignable(e.Receiver) {
+			c.emit(opEval, c.node(e), 0)
+			break
+		}
+
+		c.compile(e.Receiver)
+		for _, arg := range e.Args {
+			c.compile(arg)
+		}
+		c.emit(opMethod, c.node(e), len(e.Args))
+
+	case FieldAccess:
+		c.compile(e.Receiver)
+		c.emit(opField, c.node(e), 0)
+	case Index:
+		c.compile(e.Target)
+		c.compile(e.Position)
+		c.emit(opIndex, c.node(e), 0)
+
+	case BoxExpr:
+		// boxes don't use any variables
+		c.emit(opEval, c.node(e), 1)
+
+	default:
+		c.emit(opEval, c.node(e), 0)
+	}
+}
+
+// compileTail is compile for the last expression of a function body, calls
+// that end the function become tail calls
+func (c *Chunk) compileTail(e Expression) {
+	switch e := e.(type) {
+	case Block:
+		if len(e.Exprs) == 0 {
+			c.emit(opConst, c.constant(nil), 0)
+		}
+		for i, expr := range e.Exprs {
+			if i < len(e.Exprs)-1 {
+				c.compile(expr)
+				c.emit(opPop, 0, 0)
+			} else {
+				c.compileTail(expr)
+			}
+		}
+	case IfElifElse:
+		c.compileIf(e, c.compileTail)
+	case FunctionCall:
+		c.emit(opFunc, c.name(e.Name), 0)
+		for _, arg := range e.Args {
+			c.compile(arg)
+		}
+		c.emit(opTailCall, len(e.Args), c.node(e))
+	default:
+		c.compile(e)
+	}
+}
+
+// compileIf emits the conditions of e, with the bodies compiled by body
+func (c *Chunk) compileIf(e IfElifElse, body func(Expression)) {
+	conditions := append([]Conditional{e.If}, e.ElseIf...)
+
+	var toEnd []int
+	for i, conditional := range conditions {
+		message := "if condition should return BooleanData"
+		if i > 0 {
+			message = fmt.Sprintf("%d'th elif condition should return BooleanDat", i-1)
+		}
+
+		c.compile(conditional.Condition)
+		next := c.emit(opJumpFalse, 0, c.constant(StringData{Value: message}))
+		body(conditional.Body)
+		toEnd = append(toEnd, c.emit(opJump, 0, 0))
+		c.code[next].a = len(c.code)
+	}
+
+	if e.Else != nil {
+		body(e.Else)
+	} else {
+		c.emit(opConst, c.constant(NoData{}), 0)
+	}
+	for _, jump := range toEnd {
+		c.code[jump].a = len(c.code)
+	}
+}
+
+// compileLoop emits the loop over the iterator on top of the stack
+func (c *Chunk) compileLoop(iterator string, body Block) {
+	start := c.emit(opNext, 0, 0)
+	c.emit(opStore, c.name(iterator), c.slot(iterator))
+	c.compile(body)
+	c.emit(opPop, 0, 0)
+	c.emit(opJump, start, 0)
+
+	c.code[start].a = len(c.code)
+	c.emit(opDelete, c.name(iterator), c.slot(iterator))
+	c.emit(opConst, c.constant(NoData{}), 0)
+}
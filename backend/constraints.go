@@ -1,5 +1,7 @@
 package backend
 
+import "fmt"
+
 type constraintType int
 
 const (
@@ -13,4 +15,16 @@ type Constraint struct {
 	LeftItemName   string
 	RightItemName  string
 	ConstraintType constraintType
+	Line           int
+}
+
+var constraintTypeToStr = map[constraintType]string{
+	Below: "is below",
+	Above: "is above",
+	Left:  "is left of",
+	Right: "is right of",
+}
+
+func (c Constraint) String() string {
+	return fmt.Sprintf("%s %s %s", c.LeftItemName, constraintTypeToStr[c.ConstraintType], c.RightItemName)
 }
@@ -2,6 +2,9 @@ package backend
 
 import (
 	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -34,6 +37,9 @@ type FunctionData struct {
 	Name string
 	Args []string
 	Body Expression
+
+	code  *Chunk         // set when the function was declared by compiled code
+	cache *compiledCache // where the vm keeps the code it compiles for the function otherwise
 }
 
 func (f FunctionData) String() string {
@@ -55,6 +61,16 @@ func (f FunctionData) String() string {
 	return sb.String()
 }
 
+// NativeFunctionData is a function implemented in go, Arity -1 takes any
+// number of arguments
+type NativeFunctionData struct {
+	Name  string
+	Arity int
+	Fn    func(args []Data) (Data, error)
+}
+
+func (nf NativeFunctionData) String() string { return fmt.Sprintf("NativeFunctionData:%s", nf.Name) }
+
 type NoData struct{}
 
 func (nd NoData) String() string { return "NoData" }
@@ -76,5 +92,192 @@ func (ld ListData) String() string {
 }
 
 func (ld ListData) Index(r Runtime, position Expression) Data {
-	return ld.Values[position.Eval(r).(IntData).Value]
+	return ld.Values[normalizeIndex(position.Eval(r), len(ld.Values), "index", 0)]
+}
+
+// MapData keeps its keys in insertion order so printing and iterating are stable
+type MapData struct {
+	Keys   []string
+	Values map[string]Data
+}
+
+func NewMapData() MapData {
+	return MapData{Values: map[string]Data{}}
+}
+
+func (md MapData) String() string {
+	var sb strings.Builder
+
+	sb.WriteString("MapData:{ ")
+	for _, key := range md.Keys {
+		sb.WriteString(fmt.Sprintf("%s=%s ", key, md.Values[key]))
+	}
+	sb.WriteString("}")
+
+	return sb.String()
+}
+
+// Set returns a copy of the map with key bound to value
+func (md MapData) Set(key string, value Data) MapData {
+	newMap := NewMapData()
+	newMap.Keys = append(newMap.Keys, md.Keys...)
+	for k, v := range md.Values {
+		newMap.Values[k] = v
+	}
+
+	if _, ok := md.Values[key]; !ok {
+		newMap.Keys = append(newMap.Keys, key)
+	}
+	newMap.Values[key] = value
+
+	return newMap
+}
+
+// Delete returns a copy of the map without key
+func (md MapData) Delete(key string) MapData {
+	newMap := NewMapData()
+	for _, k := range md.Keys {
+		if k != key {
+			newMap.Keys = append(newMap.Keys, k)
+			newMap.Values[k] = md.Values[k]
+		}
+	}
+
+	return newMap
+}
+
+// RecordTypeData is what a `record` declaration binds its name to, calling it
+// constructs a RecordData
+type RecordTypeData struct {
+	Name   string
+	Fields []string
+}
+
+func (rt RecordTypeData) String() string {
+	return fmt.Sprintf("record %s { %s }", rt.Name, strings.Join(rt.Fields, ", "))
+}
+
+type RecordData struct {
+	Type   string
+	Fields []string
+	Values map[string]Data
+}
+
+func (rd RecordData) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(rd.Type + "{ ")
+	for _, field := range rd.Fields {
+		sb.WriteString(fmt.Sprintf("%s=%s ", field, rd.Values[field]))
+	}
+	sb.WriteString("}")
+
+	return sb.String()
+}
+
+// Equal compares values structurally, ignoring literals, lists and maps are
+// compared element by element
+func Equal(a, b Data) bool {
+	switch a := a.(type) {
+	case IntData:
+		b, ok := b.(IntData)
+		return ok && a.Value == b.Value
+	case StringData:
+		b, ok := b.(StringData)
+		return ok && a.Value == b.Value
+	case BooleanData:
+		b, ok := b.(BooleanData)
+		return ok && a.Value == b.Value
+	case NoData:
+		_, ok := b.(NoData)
+		return ok
+	case ListData:
+		b, ok := b.(ListData)
+		if !ok || len(a.Values) != len(b.Values) {
+			return false
+		}
+		for i := range a.Values {
+			if !Equal(a.Values[i], b.Values[i]) {
+				return false
+			}
+		}
+		return true
+	case MapData:
+		b, ok := b.(MapData)
+		if !ok || len(a.Keys) != len(b.Keys) {
+			return false
+		}
+		for key, value := range a.Values {
+			other, ok := b.Values[key]
+			if !ok || !Equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case RecordData:
+		b, ok := b.(RecordData)
+		if !ok || a.Type != b.Type {
+			return false
+		}
+		for _, field := range a.Fields {
+			if !Equal(a.Values[field], b.Values[field]) {
+				return false
+			}
+		}
+		return true
+	default:
+		// functions and layout items
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+// Format is how a value looks to the person running the program, unlike
+// String which is for debugging: 5, hi, [1, "a"], {"w": 1}, Card{title: "hi"}
+func Format(data Data) string {
+	switch data := data.(type) {
+	case IntData:
+		return strconv.Itoa(data.Value)
+	case StringData:
+		return data.Value
+	case BooleanData:
+		return strconv.FormatBool(data.Value)
+	case NoData:
+		return "none"
+	case ListData:
+		var values []string
+		for _, value := range data.Values {
+			values = append(values, formatNested(value))
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	case MapData:
+		var entries []string
+		for _, key := range data.Keys {
+			entries = append(entries, fmt.Sprintf("%q: %s", key, formatNested(data.Values[key])))
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	case RecordData:
+		var fields []string
+		for _, field := range data.Fields {
+			fields = append(fields, fmt.Sprintf("%s: %s", field, formatNested(data.Values[field])))
+		}
+		return data.Type + "{" + strings.Join(fields, ", ") + "}"
+	case FunctionData:
+		return fmt.Sprintf("<function %s>", data.Name)
+	case NativeFunctionData:
+		return fmt.Sprintf("<function %s>", data.Name)
+	case RecordTypeData:
+		return fmt.Sprintf("<record %s>", data.Name)
+	case ModuleData:
+		return fmt.Sprintf("<module %s>", filepath.Base(data.Path))
+	default:
+		return data.String()
+	}
+}
+
+// formatNested quotes strings inside collections so ["a, b"] isn't [a, b]
+func formatNested(data Data) string {
+	if s, ok := data.(StringData); ok {
+		return strconv.Quote(s.Value)
+	}
+	return Format(data)
 }
@@ -0,0 +1,205 @@
+package backend
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Hook is called around every expression the interpreter evaluates, pos is
+// where the expression starts for statements in a parsed block and zero for
+// everything else. After isn't called if the expression raised
+type Hook interface {
+	Before(r Runtime, e Expression, pos Position)
+	After(r Runtime, e Expression, pos Position, result Data)
+}
+
+// CallHook is a Hook that's also told when calls to functions (including
+// natives) start and finish, a tail call is the caller leaving and the callee
+// entering. The bytecode vm calls these but not Before and After
+type CallHook interface {
+	Hook
+	Enter(r Runtime, frame StackFrame)
+	Leave(r Runtime)
+}
+
+// SolverHook is a Hook that's told each time boxes, groups or constraints are
+// added to the solver and how long it took, a hook wrapping a Profiler passes
+// these on so it can count solver time
+type SolverHook interface {
+	Hook
+	Solved(r Runtime, line int, elapsed time.Duration)
+}
+
+// StepMode is how a Debugger carries on after stopping
+type StepMode int
+
+const (
+	Continue StepMode = iota // run to the next breakpoint
+	StepOver                 // stop at the next statement of this function or its callers
+	StepInto                 // stop at the next statement anywhere
+	StepOut                  // stop at the next statement once this function returns
+)
+
+// Stop is a place a Debugger paused the program
+type Stop struct {
+	Reason   string // "entry", "breakpoint", "step" or "pause"
+	Position Position
+	Expr     Expression // the statement about to run
+	Runtime  Runtime    // the scope it runs in
+	Stack    []StackFrame
+	// Scopes are the scopes of each call in Stack followed by the program's,
+	// the zero Runtime for calls that haven't run a statement (like natives)
+	Scopes []Runtime
+}
+
+// Breakpoint is a line in a file, File is compared with the File of the
+// runtime running it
+type Breakpoint struct {
+	File string
+	Line int
+}
+
+// Debugger is a Hook that pauses before statements on breakpoint lines, or
+// the next statement while stepping, and asks OnStop how to carry on
+type Debugger struct {
+	// OnStop is called while the program is paused, it can inspect the stop
+	// and change breakpoints before returning
+	OnStop      func(stop Stop) StepMode
+	StopOnEntry bool
+
+	mu          sync.Mutex
+	breakpoints map[Breakpoint]bool
+	started     bool
+	mode        StepMode
+	depth       int // call depth of the last stop
+	pausing     bool
+	scopes      []scope // by call depth, the program's first
+}
+
+// scope is the runtime the statements of a call last ran in
+type scope struct {
+	frame   StackFrame
+	runtime Runtime
+}
+
+func NewDebugger(onStop func(stop Stop) StepMode) *Debugger {
+	return &Debugger{OnStop: onStop, breakpoints: map[Breakpoint]bool{}}
+}
+
+// SetBreakpoint adds or removes a breakpoint on a line of file, it's safe to
+// call while the program runs
+func (d *Debugger) SetBreakpoint(file string, line int, on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if on {
+		d.breakpoints[Breakpoint{File: file, Line: line}] = true
+	} else {
+		delete(d.breakpoints, Breakpoint{File: file, Line: line})
+	}
+}
+
+// Breakpoints are the breakpoints in order of file then line
+func (d *Debugger) Breakpoints() []Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var breakpoints []Breakpoint
+	for bp := range d.breakpoints {
+		breakpoints = append(breakpoints, bp)
+	}
+	sort.Slice(breakpoints, func(i, j int) bool {
+		if breakpoints[i].File != breakpoints[j].File {
+			return breakpoints[i].File < breakpoints[j].File
+		}
+		return breakpoints[i].Line < breakpoints[j].Line
+	})
+
+	return breakpoints
+}
+
+// Pause stops at the next statement, it's safe to call while the program runs
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pausing = true
+}
+
+func (d *Debugger) Before(r Runtime, e Expression, pos Position) {
+	if pos.Line == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	depth := r.calls.depth()
+	d.enter(r, depth)
+
+	var reason string
+	switch {
+	case !d.started && d.StopOnEntry:
+		reason = "entry"
+	case d.pausing:
+		reason = "pause"
+	case d.mode == StepInto,
+		d.mode == StepOver && depth <= d.depth,
+		d.mode == StepOut && depth < d.depth:
+		reason = "step"
+	case d.breakpoints[Breakpoint{File: pos.File, Line: pos.Line}]:
+		reason = "breakpoint"
+	}
+	d.started = true
+	if reason == "" {
+		d.mu.Unlock()
+		return
+	}
+
+	d.pausing = false
+	stack := r.CallStack()
+	scopes := d.frameScopes(stack)
+	d.mu.Unlock()
+
+	mode := d.OnStop(Stop{Reason: reason, Position: pos, Expr: e, Runtime: r, Stack: stack, Scopes: scopes})
+
+	d.mu.Lock()
+	d.mode, d.depth = mode, depth
+	d.mu.Unlock()
+}
+
+// enter records r as the scope of the call at depth, forgetting deeper calls
+// since they must have returned
+func (d *Debugger) enter(r Runtime, depth int) {
+	if len(d.scopes) > depth {
+		d.scopes = d.scopes[:depth]
+	}
+	for len(d.scopes) < depth {
+		d.scopes = append(d.scopes, scope{})
+	}
+
+	var frame StackFrame
+	if depth > 0 {
+		frame = r.calls.frames[depth-1]
+	}
+	d.scopes = append(d.scopes, scope{frame: frame, runtime: r})
+}
+
+// frameScopes lines the recorded scopes up with stack, a scope recorded for
+// a call at the same depth from somewhere else is from a call that returned
+func (d *Debugger) frameScopes(stack []StackFrame) []Runtime {
+	scopes := make([]Runtime, len(stack)+1)
+	for i := range scopes {
+		depth := len(stack) - i
+		if depth >= len(d.scopes) {
+			continue
+		}
+		if depth > 0 && !d.scopes[depth].frame.site(stack[i]) {
+			continue
+		}
+		scopes[i] = d.scopes[depth].runtime
+	}
+
+	return scopes
+}
+
+func (d *Debugger) After(r Runtime, e Expression, pos Position, result Data) {}
@@ -0,0 +1,20 @@
+package backend
+
+import "fmt"
+
+// RuntimeError is raised when a program does something invalid while it runs
+// (dividing by zero etc.), execute.Run recovers it and hands it back as an error
+type RuntimeError struct {
+	Message string
+	Err     error        // what caused it (one of the Err* values, an error from a native function), for errors.Is
+	Stack   []StackFrame // the calls it was raised in, innermost first
+}
+
+func (re RuntimeError) Error() string { return re.Message }
+
+func (re RuntimeError) Unwrap() error { return re.Err }
+
+// raise aborts evaluation with a RuntimeError
+func raise(format string, args ...any) {
+	panic(RuntimeError{Message: fmt.Sprintf(format, args...)})
+}
@@ -23,12 +23,37 @@ func (a Assign) String() string {
 }
 
 func (a Assign) Eval(r Runtime) Data {
-	r.SymbolTable[a.Name] = a.Expr.Eval(r)
+	r.SymbolTable[a.Name] = eval(r, a.Expr)
 	return NoData{}
 }
 
 type Block struct {
 	Exprs []Expression
+	// Positions are where each expression starts in the source, nil for
+	// blocks that weren't parsed
+	Positions []Position
+}
+
+// Position is a line and column in the source, both start at 1. File is the
+// runtime's File for positions given to hooks and empty otherwise
+type Position struct {
+	File         string
+	Line, Column int
+}
+
+func (p Position) String() string {
+	if p.File != "" {
+		return fmt.Sprintf("%s line %d:%d", p.File, p.Line, p.Column)
+	}
+	return fmt.Sprintf("line %d:%d", p.Line, p.Column)
+}
+
+// position is where the i'th expression starts, zero if it isn't known
+func (b Block) position(i int) Position {
+	if i < len(b.Positions) {
+		return b.Positions[i]
+	}
+	return Position{}
 }
 
 func (b Block) String() string {
@@ -46,8 +71,8 @@ func (b Block) Eval(r Runtime) Data {
 
 	outsideScope := util.DeepCopyMap(r.SymbolTable)
 
-	for _, expr := range b.Exprs {
-		last = expr.Eval(r)
+	for i, expr := range b.Exprs {
+		last = evalAt(r, expr, b.position(i))
 	}
 
 	r.SymbolTable = outsideScope
@@ -64,9 +89,9 @@ func (d Dereference) String() string {
 }
 
 func (d Dereference) Eval(r Runtime) Data {
-	val, ok := r.SymbolTable[d.Name]
+	val, ok := r.lookup(d.Name)
 	if !ok {
-		panic(fmt.Sprintf("Attempt to dereference uninitialized variable %s", d.Name))
+		raise("Attempt to dereference uninitialized variable %s", d.Name)
 	}
 
 	return val
@@ -79,6 +104,8 @@ const (
 	SUB
 	DIV
 	MUL
+	MOD
+	POW
 )
 
 type Arithmetic struct {
@@ -92,6 +119,19 @@ var ArithOpToStr = map[ArithOp]string{
 	SUB: "-",
 	DIV: "/",
 	MUL: "*",
+	MOD: "%",
+	POW: "**",
+}
+
+// ArithOpFromStr is used by the parser to map an operator token back to its ArithOp
+func ArithOpFromStr(op string) ArithOp {
+	for arithOp, str := range ArithOpToStr {
+		if str == op {
+			return arithOp
+		}
+	}
+
+	panic(fmt.Sprintf("unknown arithmetic operator %s", op))
 }
 
 func (a Arithmetic) String() string {
@@ -99,13 +139,18 @@ func (a Arithmetic) String() string {
 }
 
 func (a Arithmetic) Eval(r Runtime) Data {
-	rightValue, ok := a.Right.Eval(r).(IntData)
+	return a.apply(eval(r, a.Left), eval(r, a.Right))
+}
+
+// apply does the operation on already evaluated sides
+func (a Arithmetic) apply(left, right Data) Data {
+	leftValue, ok := left.(IntData)
 	if !ok {
-		panic("Arithmetic Eval given non IntData for right Expr")
+		raise("operator %s given non IntData for left side of `%s`", ArithOpToStr[a.Op], a)
 	}
-	leftValue, ok := a.Left.Eval(r).(IntData)
+	rightValue, ok := right.(IntData)
 	if !ok {
-		panic("Arithmetic Eval given non IntData for left Expr")
+		raise("operator %s given non IntData for right side of `%s`", ArithOpToStr[a.Op], a)
 	}
 
 	switch a.Op {
@@ -114,15 +159,58 @@ func (a Arithmetic) Eval(r Runtime) Data {
 	case SUB:
 		return IntData{Value: leftValue.Value - rightValue.Value, Literal: a.String()}
 	case DIV:
+		if rightValue.Value == 0 {
+			raise("division by zero in `%s`", a)
+		}
 		return IntData{Value: leftValue.Value / rightValue.Value, Literal: a.String()}
 	case MUL:
 		return IntData{Value: leftValue.Value * rightValue.Value, Literal: a.String()}
+	case MOD:
+		if rightValue.Value == 0 {
+			raise("modulo by zero in `%s`", a)
+		}
+		return IntData{Value: leftValue.Value % rightValue.Value, Literal: a.String()}
+	case POW:
+		if rightValue.Value < 0 {
+			raise("negative exponent in `%s`", a)
+		}
+		// by squaring so huge exponents take a few dozen steps, it overflows
+		// the same way repeated * would
+		result, base := 1, leftValue.Value
+		for exponent := rightValue.Value; exponent > 0; exponent >>= 1 {
+			if exponent&1 == 1 {
+				result *= base
+			}
+			base *= base
+		}
+		return IntData{Value: result, Literal: a.String()}
 	default:
 		panic(fmt.Sprintf("Unknown operation %d", a.Op))
 	}
 
 }
 
+type Negate struct {
+	Expr Expression
+}
+
+func (n Negate) String() string {
+	return fmt.Sprintf("-%s", n.Expr)
+}
+
+func (n Negate) Eval(r Runtime) Data {
+	return n.apply(eval(r, n.Expr))
+}
+
+func (n Negate) apply(data Data) Data {
+	value, ok := data.(IntData)
+	if !ok {
+		raise("unary - given non IntData in `%s`", n)
+	}
+
+	return IntData{Value: -value.Value, Literal: n.String()}
+}
+
 type CmpOp int
 
 const (
@@ -131,6 +219,9 @@ const (
 	EQ
 	AND
 	OR
+	NEQ
+	LTE
+	GTE
 )
 
 var CmpOpToStr = map[CmpOp]string{
@@ -139,6 +230,20 @@ var CmpOpToStr = map[CmpOp]string{
 	EQ:  "==",
 	AND: "and",
 	OR:  "or",
+	NEQ: "!=",
+	LTE: "<=",
+	GTE: ">=",
+}
+
+// CmpOpFromStr is used by the parser to map an operator token back to its CmpOp
+func CmpOpFromStr(op string) CmpOp {
+	for cmpOp, str := range CmpOpToStr {
+		if str == op {
+			return cmpOp
+		}
+	}
+
+	panic(fmt.Sprintf("unknown comparison operator %s", op))
 }
 
 type Compare struct {
@@ -153,9 +258,15 @@ func (c Compare) String() string {
 
 // ugly ahh function
 func (c Compare) Eval(r Runtime) Data {
-	left := c.Left.Eval(r)
-	right := c.Right.Eval(r)
+	if c.Op == AND || c.Op == OR {
+		return c.evalLogical(r)
+	}
 
+	return c.apply(eval(r, c.Left), eval(r, c.Right))
+}
+
+// apply compares already evaluated sides, it isn't used for and/or
+func (c Compare) apply(left, right Data) Data {
 	// Both Int
 	leftInt, okLeft := left.(IntData)
 	rightInt, okRight := right.(IntData)
@@ -170,28 +281,43 @@ func (c Compare) Eval(r Runtime) Data {
 		return CompareData(leftString.Value, rightString.Value, c.Op)
 	}
 
-	// Both Boolean
-	leftBool, okLeft := left.(BooleanData)
-	rightBool, okRight := right.(BooleanData)
-	if okLeft && okRight {
-		switch c.Op {
-		case EQ:
-			return BooleanData{Value: leftBool == rightBool, Literal: fmt.Sprintf("%t", leftBool == rightBool)}
-		case AND:
-			result := leftBool.Value && rightBool.Value
-			return BooleanData{Value: result, Literal: fmt.Sprintf("%t", result)}
-		case OR:
-			result := leftBool.Value || rightBool.Value
-			return BooleanData{Value: result, Literal: fmt.Sprintf("%t", result)}
-		default:
-			panic(fmt.Sprintf("Operator %s undefined for BooleanData", CmpOpToStr[c.Op]))
-		}
+	// everything else (booleans, lists, maps, mixed types) only supports equality
+	switch c.Op {
+	case EQ:
+		result := Equal(left, right)
+		return BooleanData{Value: result, Literal: fmt.Sprintf("%t", result)}
+	case NEQ:
+		result := !Equal(left, right)
+		return BooleanData{Value: result, Literal: fmt.Sprintf("%t", result)}
+	default:
+		raise("Operator %s not supported for %T and %T", CmpOpToStr[c.Op], left, right)
+		return NoData{}
+	}
+}
 
+// evalLogical short circuits, the right side is only evaluated when it decides the result
+func (c Compare) evalLogical(r Runtime) Data {
+	left := c.operand(eval(r, c.Left), "left")
+	if c.decided(left) {
+		return left
 	}
 
-	panic("Comparison not supported for given data types")
+	return c.operand(eval(r, c.Right), "right")
+}
 
-	return NoData{}
+// operand checks one side of and/or is a boolean
+func (c Compare) operand(data Data, side string) BooleanData {
+	value, ok := data.(BooleanData)
+	if !ok {
+		raise("Operator %s expects BooleanData on the %s", CmpOpToStr[c.Op], side)
+	}
+
+	return BooleanData{Value: value.Value, Literal: fmt.Sprintf("%t", value.Value)}
+}
+
+// decided is true when the left side of and/or is enough to know the result
+func (c Compare) decided(left BooleanData) bool {
+	return (c.Op == AND && !left.Value) || (c.Op == OR && left.Value)
 }
 
 func CompareData[T cmp.Ordered](a, b T, op CmpOp) BooleanData {
@@ -203,6 +329,12 @@ func CompareData[T cmp.Ordered](a, b T, op CmpOp) BooleanData {
 		result = a > b
 	case EQ:
 		result = a == b
+	case NEQ:
+		result = a != b
+	case LTE:
+		result = a <= b
+	case GTE:
+		result = a >= b
 	default:
 		panic(fmt.Sprintf("unhandled comparison operator %s", CmpOpToStr[op]))
 	}
@@ -210,6 +342,27 @@ func CompareData[T cmp.Ordered](a, b T, op CmpOp) BooleanData {
 	return BooleanData{Value: result, Literal: fmt.Sprintf("%t", result)}
 }
 
+type Not struct {
+	Expr Expression
+}
+
+func (n Not) String() string {
+	return fmt.Sprintf("not %s", n.Expr)
+}
+
+func (n Not) Eval(r Runtime) Data {
+	return n.apply(eval(r, n.Expr))
+}
+
+func (n Not) apply(data Data) Data {
+	value, ok := data.(BooleanData)
+	if !ok {
+		raise("not given non BooleanData")
+	}
+
+	return BooleanData{Value: !value.Value, Literal: fmt.Sprintf("%t", !value.Value)}
+}
+
 type Concat struct {
 	Left  Expression
 	Right Expression
@@ -220,14 +373,19 @@ func (c Concat) String() string {
 }
 
 func (c Concat) Eval(r Runtime) Data {
-	leftStringData, ok := c.Left.Eval(r).(StringData)
+	return c.apply(r, eval(r, c.Left), eval(r, c.Right))
+}
+
+func (c Concat) apply(r Runtime, left, right Data) Data {
+	leftStringData, ok := left.(StringData)
 	if !ok {
-		panic("Concat left given non StringData")
+		raise("Concat left given non StringData")
 	}
-	rightStringData, ok := c.Right.Eval(r).(StringData)
+	rightStringData, ok := right.(StringData)
 	if !ok {
-		panic("Concat right given non StringData")
+		raise("Concat right given non StringData")
 	}
+	checkStringSize(r, len(leftStringData.Value)+len(rightStringData.Value))
 
 	value := fmt.Sprintf("%s%s", leftStringData.Value, rightStringData.Value)
 	return StringData{
@@ -258,21 +416,33 @@ func (l Loop) String() string {
 	return sb.String()
 }
 
+// bounds checks the evaluated start, stop and step are ints
+func (l Loop) bounds(start, stop, step Data) (int, int, int) {
+	var ints [3]int
+	for i, value := range []Data{start, stop, step} {
+		n, ok := value.(IntData)
+		if !ok {
+			raise("for %s in (%s, %s, %s) %s must be IntData got %s", l.Iterator, l.Start, l.Stop, l.Step,
+				[...]string{"start", "stop", "step"}[i], value)
+		}
+		ints[i] = n.Value
+	}
+
+	return ints[0], ints[1], ints[2]
+}
+
 func (l Loop) Eval(r Runtime) Data {
-	// will panic if wrong type btw
-	startInt := l.Start.Eval(r).(IntData).Value
-	stopInt := l.Stop.Eval(r).(IntData).Value
-	stepInt := l.Step.Eval(r).(IntData).Value
+	startInt, stopInt, stepInt := l.bounds(eval(r, l.Start), eval(r, l.Stop), eval(r, l.Step))
 
 	if stepInt < 0 {
 		for i := startInt; i > stopInt; i += stepInt {
 			r.SymbolTable[l.Iterator] = IntData{Value: i, Literal: fmt.Sprintf("%d", i)}
-			l.Body.Eval(r)
+			eval(r, l.Body)
 		}
 	} else {
 		for i := startInt; i < stopInt; i += stepInt {
 			r.SymbolTable[l.Iterator] = IntData{Value: i, Literal: fmt.Sprintf("%d", i)}
-			l.Body.Eval(r)
+			eval(r, l.Body)
 		}
 	}
 
@@ -281,23 +451,100 @@ func (l Loop) Eval(r Runtime) Data {
 	return NoData{} // loop don't return stuff right?
 }
 
+// ForEach loops over the values of a list or the keys of a map
+type ForEach struct {
+	Iterator string
+	Iterable Expression
+	Body     Block
+}
+
+func (fe ForEach) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("for %s in %s {\n", fe.Iterator, fe.Iterable))
+	for _, expr := range fe.Body.Exprs {
+		sb.WriteString(expr.String())
+		sb.WriteString("\n")
+	}
+	sb.WriteString("}\n")
+
+	return sb.String()
+}
+
+func (fe ForEach) Eval(r Runtime) Data {
+	for _, item := range items(eval(r, fe.Iterable)) {
+		r.SymbolTable[fe.Iterator] = item
+		eval(r, fe.Body)
+	}
+
+	delete(r.SymbolTable, fe.Iterator)
+
+	return NoData{}
+}
+
+// items is what a for loop over iterable goes through
+func items(iterable Data) []Data {
+	var items []Data
+
+	switch iterable := iterable.(type) {
+	case ListData:
+		items = iterable.Values
+	case MapData:
+		for _, key := range iterable.Keys {
+			items = append(items, StringData{Value: key, Literal: fmt.Sprintf("%q", key)})
+		}
+	default:
+		raise("can't iterate over %T", iterable)
+	}
+
+	return items
+}
+
+// Print writes its arguments Formatted and separated by spaces to the runtime's Stdout
 type Print struct {
-	ToPrint Expression
+	Args []Expression
 }
 
 func (p Print) String() string {
-	return fmt.Sprintf("print(%s)", p.ToPrint)
+	var args []string
+	for _, arg := range p.Args {
+		args = append(args, arg.String())
+	}
+
+	return fmt.Sprintf("print(%s)", strings.Join(args, ", "))
 }
 
 func (p Print) Eval(r Runtime) Data {
-	fmt.Println(p.ToPrint.Eval(r))
+	var args []Data
+	for _, arg := range p.Args {
+		args = append(args, eval(r, arg))
+	}
+
+	return p.write(r, args)
+}
+
+func (p Print) write(r Runtime, args []Data) Data {
+	var values []string
+	for _, arg := range args {
+		values = append(values, Format(arg))
+	}
+
+	out := r.Stdout
+	if out == nil {
+		out = os.Stdout
+	}
+	if _, err := fmt.Fprintln(out, strings.Join(values, " ")); err != nil {
+		raise("print: %s", err)
+	}
+
 	return NoData{}
 }
 
 type Declare struct {
-	Name string
-	Args []string
-	Body Expression
+	Name     string
+	Args     []string
+	ArgTypes []string // optional annotations, "" where a parameter has none
+	Body     Expression
 }
 
 func (d Declare) String() string {
@@ -321,9 +568,10 @@ func (d Declare) String() string {
 
 func (d Declare) Eval(r Runtime) Data {
 	r.SymbolTable[d.Name] = FunctionData{
-		Name: d.Name,
-		Args: d.Args,
-		Body: d.Body,
+		Name:  d.Name,
+		Args:  d.Args,
+		Body:  d.Body,
+		cache: &compiledCache{},
 	}
 
 	return NoData{}
@@ -332,6 +580,8 @@ func (d Declare) Eval(r Runtime) Data {
 type FunctionCall struct {
 	Name string
 	Args []Expression
+	// Line and Column are where the call is in the source, for stack traces
+	Line, Column int
 }
 
 func (fc FunctionCall) String() string {
@@ -348,28 +598,94 @@ func (fc FunctionCall) String() string {
 }
 
 func (fc FunctionCall) Eval(r Runtime) Data {
-	f := r.SymbolTable[fc.Name]
-	if f == nil {
-		panic(fmt.Sprintf("function %s doesn't exist", fc.Name))
+	f, args := fc.evalCallee(r)
+	return callFrame(r, fc.frame(r, args), f)
+}
+
+// frame is the StackFrame for this call from r with args
+func (fc FunctionCall) frame(r Runtime, args []Data) StackFrame {
+	return StackFrame{Function: fc.Name, File: r.File, Line: fc.Line, Column: fc.Column, Args: args}
+}
+
+// evalCallee looks up the function and evaluates the arguments
+func (fc FunctionCall) evalCallee(r Runtime) (Data, []Data) {
+	f, ok := r.lookup(fc.Name)
+	if !ok {
+		raise("function %s doesn't exist", fc.Name)
+	}
+
+	var args []Data
+	for _, arg := range fc.Args {
+		args = append(args, eval(r, arg))
+	}
+
+	return f, args
+}
+
+// call applies a function value (or record constructor) to evaluated arguments,
+// it's shared by FunctionCall and methods that take functions like list.map
+func call(r Runtime, name string, f Data, args []Data) Data {
+	return callFrame(r, StackFrame{Function: name, Args: args}, f)
+}
+
+// callFrame is call with where it was called from, the frame is on the
+// runtime's call stack while f runs
+func callFrame(r Runtime, frame StackFrame, f Data) Data {
+	r.calls.enter(r, frame)
+	defer r.calls.leave(r)
+	defer r.calls.annotate()
+
+	name, args := frame.Function, frame.Args
+	if recordType, ok := f.(RecordTypeData); ok {
+		return recordType.construct(args)
+	}
+	if native, ok := f.(NativeFunctionData); ok {
+		if native.Arity >= 0 && native.Arity != len(args) {
+			raise("function %s expects %d args got %d", name, native.Arity, len(args))
+		}
+		result, err := native.Fn(args)
+		if err != nil {
+			panic(RuntimeError{Message: fmt.Sprintf("%s: %s", name, err), Err: err})
+		}
+		checkNative(r, result)
+		return result
 	}
 	funcData, ok := f.(FunctionData)
 	if !ok {
-		panic(fmt.Sprintf("function %s is not type FunctionData is %T", fc.Name, f))
+		raise("function %s is not type FunctionData is %T", name, f)
+	}
+
+	// tail calls come back from evalTail and run here, so recursion in tail
+	// position doesn't grow the stack
+	for {
+		result := evalTail(funcData.scope(r, frame.Function, frame.Args), funcData.Body, Position{})
+
+		next, ok := result.(tailCall)
+		if !ok {
+			return result
+		}
+		r, frame, funcData = next.r, next.frame, next.function
+		r.calls.replace(r, frame)
 	}
-	if len(funcData.Args) != len(fc.Args) {
-		panic(fmt.Sprintf("function %s expects %d args got %d", fc.Name, len(funcData.Args), len(fc.Args)))
+}
+
+// scope is the runtime the function's body runs in when called from r,
+// functions without arguments share their callers variables
+func (fd FunctionData) scope(r Runtime, name string, args []Data) Runtime {
+	if len(fd.Args) != len(args) {
+		raise("function %s expects %d args got %d", name, len(fd.Args), len(args))
 	}
 
-	var functionArgs = map[string]Data{}
-	for i, arg := range fc.Args {
-		functionArgs[funcData.Args[i]] = arg.Eval(r) // assignment to en
+	if len(args) == 0 {
+		return r
 	}
 
-	if len(functionArgs) > 0 {
-		return funcData.Body.Eval(r.SubScope(functionArgs))
+	var functionArgs = map[string]Data{}
+	for i, arg := range args {
+		functionArgs[fd.Args[i]] = arg // assignment to en
 	}
 
-	return funcData.Body.Eval(r)
+	return r.SubScope(functionArgs)
 }
 
 // Conditional is util not an expr
@@ -408,33 +724,44 @@ func (iee IfElifElse) String() string {
 }
 
 func (iee IfElifElse) Eval(r Runtime) Data {
+	body, ok := iee.branch(r)
+	if !ok {
+		return NoData{}
+	}
+
+	return eval(r, body)
+}
+
+// branch evaluates the conditions and returns the body that should run,
+// ok is false when nothing matched and there's no else
+func (iee IfElifElse) branch(r Runtime) (Expression, bool) {
 	// if
-	ifConditionResult, ok := iee.If.Condition.Eval(r).(BooleanData)
+	ifConditionResult, ok := eval(r, iee.If.Condition).(BooleanData)
 	if !ok {
-		panic("if condition should return BooleanData")
+		raise("if condition should return BooleanData")
 	}
 	if ifConditionResult.Value {
-		return iee.If.Body.Eval(r)
+		return iee.If.Body, true
 	}
 
 	// elif's
 	for i, elseIf := range iee.ElseIf {
-		elseIfConditionResult, ok := elseIf.Condition.Eval(r).(BooleanData)
+		elseIfConditionResult, ok := eval(r, elseIf.Condition).(BooleanData)
 		if !ok {
-			panic(fmt.Sprintf("%d'th elif condition should return BooleanDat", i))
+			raise("%d'th elif condition should return BooleanDat", i)
 		}
 
 		if elseIfConditionResult.Value {
-			return iee.ElseIf[i].Body.Eval(r)
+			return iee.ElseIf[i].Body, true
 		}
 	}
 
 	// else
 	if iee.Else != nil {
-		return iee.Else.Eval(r)
+		return iee.Else, true
 	}
 
-	return NoData{}
+	return nil, false
 }
 
 type List struct {
@@ -454,202 +781,219 @@ func (l List) String() string {
 }
 
 func (l List) Eval(r Runtime) Data {
+	checkListSize(r, len(l.Values))
+
 	var list ListData
 	for _, val := range l.Values {
-		list.Values = append(list.Values, val.Eval(r))
+		list.Values = append(list.Values, eval(r, val))
 	}
 
 	return list
 }
 
-type ListIndex struct {
-	List     Expression
-	Position Expression
+type BoxExpr struct {
+	Id   string
+	Line int
 }
 
-func (li ListIndex) String() string {
-	return fmt.Sprintf("%s.get(%d)", li.List.String(), li.Position)
+func (b BoxExpr) String() string {
+	return fmt.Sprintf("box: %s", b.Id)
 }
 
-func (li ListIndex) Eval(r Runtime) Data {
-	pos := li.Position.Eval(r)
+func (b BoxExpr) Eval(r Runtime) Data {
+	var box Box
+	solve(r, b.Line, func() { box = newBox(r.Solver, r.Constraints, b.Id) })
 
-	posInt, ok := pos.(IntData)
-	if !ok {
-		panic("list index position must be IntData")
-	}
+	return box
+}
 
-	valList, ok := li.List.Eval(r).(ListData)
-	if !ok {
-		panic("attempt to index non-ListData type")
-	}
+type GroupExpr struct {
+	Items       Expression
+	Constraints []Constraint
+	Line        int
+}
 
-	if len(valList.Values) < posInt.Value {
-		panic("attempt to index position outside of list")
+func (g GroupExpr) String() string {
+	var constraints []string
+	for _, c := range g.Constraints {
+		constraints = append(constraints, c.String())
 	}
 
-	return valList.Values[posInt.Value]
+	return fmt.Sprintf("Group(%s: [%s])", g.Items, strings.Join(constraints, ", "))
 }
 
-type ListDelete struct {
-	List     Expression
-	Position Expression
-}
+func (g GroupExpr) Eval(r Runtime) Data {
+	for _, c := range g.Constraints {
+		right := Dereference{strings.Trim(c.RightItemName, "*")}.Eval(r)
+		left := Dereference{strings.Trim(c.LeftItemName, "*")}.Eval(r)
 
-func (ld ListDelete) String() string {
-	return fmt.Sprintf("%s.del(%d)", ld.List, ld.Position)
-}
+		// might get expression that doesnt instantly give us a a layout item
+		// variable -> function -> returns a layout item
+		if fn, ok := right.(FunctionData); ok {
+			right = eval(r, fn.Body)
+		}
+		if fn, ok := left.(FunctionData); ok {
+			left = eval(r, fn.Body)
+		}
+		leftItem, ok := left.(LayoutItem)
+		if !ok {
+			raise("constraint item %s is not a layout item in `%s`", c.LeftItemName, c)
+		}
+		rightItem, ok := right.(LayoutItem)
+		if !ok {
+			raise("constraint item %s is not a layout item in `%s`", c.RightItemName, c)
+		}
 
-func (ld ListDelete) Eval(r Runtime) Data {
-	pos := ld.Position.Eval(r)
-	posInt, ok := pos.(IntData)
-	if !ok {
-		panic("list delete position must be IntData")
+		line := c.Line
+		if line == 0 {
+			line = g.Line
+		}
+		solve(r, line, func() {
+			switch c.ConstraintType {
+			case Below:
+				leftItem.IsBelow(rightItem)
+			case Above:
+				leftItem.IsAbove(rightItem)
+			case Left:
+				leftItem.IsLeftOf(rightItem)
+			case Right:
+				leftItem.IsRightOf(rightItem)
+			}
+		})
 	}
 
-	val := ld.List.Eval(r)
-	valList, ok := val.(ListData)
+	items, ok := eval(r, g.Items).(ListData)
 	if !ok {
-		panic("attempt to delete list index non-ListData type")
+		raise("Group expects a list of layout items in `%s`", g)
 	}
-
-	if len(valList.Values) < posInt.Value {
-		panic("attempt to index position outside of list")
-	}
-
-	var newList ListData
-	for i, item := range valList.Values {
-		if i != posInt.Value {
-			newList.Values = append(newList.Values, item)
+	var layoutItems []LayoutItem
+	for i, item := range items.Values {
+		layoutItem, ok := item.(LayoutItem)
+		if !ok {
+			raise("Group item %d is not a layout item in `%s`", i, g)
 		}
+		layoutItems = append(layoutItems, layoutItem)
 	}
 
-	switch list := ld.List.(type) {
-	case Dereference:
-		// update runtime
-		r.SymbolTable[list.Name] = newList
-	case List:
-		// don't update runtime
-	default:
-		panic(fmt.Sprintf("ListDelete List is not Defrefrence or List type got:%T", list))
-	}
-
-	return newList
-}
+	var group Group
+	solve(r, g.Line, func() { group = newGroup(r.Solver, r.Constraints, layoutItems...) })
 
-type ListAdd struct {
-	List  Expression
-	Value Expression
+	return group
 }
 
-func (la ListAdd) String() string {
-	return fmt.Sprintf("%s.add(%s)", la.List, la.Value)
+// StackExpr is HStack(items, spacing, alignment) or VStack, spacing and
+// alignment are optional
+type StackExpr struct {
+	Horizontal bool
+	Items      Expression
+	Spacing    Expression // nil for 0
+	Alignment  Expression // nil for the first of stackAlignments
+	Line       int
 }
 
-func (la ListAdd) Eval(r Runtime) Data {
-	valueData := la.Value.Eval(r)
-
-	runtimeData := la.List.Eval(r)
-	runtimeListData, ok := runtimeData.(ListData)
-	if !ok {
-		panic("attempt to .add to non-ListData type")
+func (s StackExpr) String() string {
+	args := []string{fmt.Sprint(s.Items)}
+	if s.Spacing != nil {
+		args = append(args, fmt.Sprint(s.Spacing))
 	}
-
-	var newList ListData
-	for _, value := range append(runtimeListData.Values, valueData) {
-		newList.Values = append(newList.Values, value)
-	}
-
-	switch list := la.List.(type) {
-	case Dereference:
-		// update runtime
-		r.SymbolTable[list.Name] = newList
-	case List:
-		// don't update runtime
-	default:
-		panic(fmt.Sprintf("ListAdd List is not Defrefrence or List type got:%T", list))
+	if s.Alignment != nil {
+		args = append(args, fmt.Sprint(s.Alignment))
 	}
 
-	return newList
-}
-
-type ListLength struct {
-	List Expression
-}
-
-func (ll ListLength) String() string {
-	return fmt.Sprintf("%s.len", ll.List)
+	return fmt.Sprintf("%s(%s)", stackKind(s.Horizontal), strings.Join(args, ", "))
 }
 
-func (ll ListLength) Eval(r Runtime) Data {
-	runtimeData := ll.List.Eval(r)
+func (s StackExpr) Eval(r Runtime) Data {
+	kind := stackKind(s.Horizontal)
 
-	runtimeListData, ok := runtimeData.(ListData)
+	items, ok := eval(r, s.Items).(ListData)
 	if !ok {
-		panic("attempt get length of non-ListData type")
+		raise("%s expects a list of layout items in `%s`", kind, s)
+	}
+	var layoutItems []LayoutItem
+	for i, item := range items.Values {
+		layoutItem, ok := item.(LayoutItem)
+		if !ok {
+			raise("%s item %d is not a layout item in `%s`", kind, i, s)
+		}
+		layoutItems = append(layoutItems, layoutItem)
 	}
 
-	return IntData{
-		Value: len(runtimeListData.Values),
+	var spacing int
+	if s.Spacing != nil {
+		value, ok := eval(r, s.Spacing).(IntData)
+		if !ok {
+			raise("%s spacing must be IntData in `%s`", kind, s)
+		}
+		spacing = value.Value
 	}
-}
 
-type BoxExpr struct {
-	Id string
-}
+	var alignment string
+	if s.Alignment != nil {
+		value, ok := eval(r, s.Alignment).(StringData)
+		if !ok {
+			raise("%s alignment must be StringData in `%s`", kind, s)
+		}
+		alignment = value.Value
+	}
 
-func (b BoxExpr) String() string {
-	return fmt.Sprintf("box: %s", b.Id)
-}
+	var stack Stack
+	solve(r, s.Line, func() {
+		stack = newStack(r.Solver, r.Constraints, s.Horizontal, float64(spacing), alignment, layoutItems...)
+	})
 
-func (b BoxExpr) Eval(r Runtime) Data {
-	return NewBox(r.Solver, b.Id)
+	return stack
 }
 
-type GroupExpr struct {
-	Items       Expression
-	Constraints []Constraint
+// GridExpr is Grid(items, columns, gap), gap is optional
+type GridExpr struct {
+	Items   Expression
+	Columns Expression
+	Gap     Expression // nil for 0
+	Line    int
 }
 
-func (g GroupExpr) String() string {
-	return g.String()
+func (g GridExpr) String() string {
+	if g.Gap == nil {
+		return fmt.Sprintf("Grid(%s, %s)", g.Items, g.Columns)
+	}
+	return fmt.Sprintf("Grid(%s, %s, %s)", g.Items, g.Columns, g.Gap)
 }
 
-func (g GroupExpr) Eval(r Runtime) Data {
-	for _, c := range g.Constraints {
-		right := Dereference{strings.Trim(c.RightItemName, "*")}.Eval(r)
-		left := Dereference{strings.Trim(c.LeftItemName, "*")}.Eval(r)
-
-		// might get expression that doesnt instantly give us a a layout item
-		// variable -> function -> returns a layout item
-		switch right.(type) {
-		case FunctionData:
-			right = right.(FunctionData).Body.Eval(r)
-		}
-		switch left.(type) {
-		case FunctionData:
-			left = left.(FunctionData).Body.Eval(r)
+func (g GridExpr) Eval(r Runtime) Data {
+	items, ok := eval(r, g.Items).(ListData)
+	if !ok {
+		raise("Grid expects a list of layout items in `%s`", g)
+	}
+	var layoutItems []LayoutItem
+	for i, item := range items.Values {
+		layoutItem, ok := item.(LayoutItem)
+		if !ok {
+			raise("Grid item %d is not a layout item in `%s`", i, g)
 		}
+		layoutItems = append(layoutItems, layoutItem)
+	}
 
-		switch c.ConstraintType {
-		case Below:
-			left.(LayoutItem).IsBelow(right.(LayoutItem))
-		case Above:
-			left.(LayoutItem).IsAbove(right.(LayoutItem))
-		case Left:
-			left.(LayoutItem).IsLeftOf(right.(LayoutItem))
-		case Right:
-			left.(LayoutItem).IsRightOf(right.(LayoutItem))
-		}
+	columns, ok := eval(r, g.Columns).(IntData)
+	if !ok {
+		raise("Grid columns must be IntData in `%s`", g)
 	}
 
-	items := g.Items.Eval(r).(ListData)
-	var layoutItems []LayoutItem
-	for _, item := range items.Values {
-		layoutItems = append(layoutItems, item.(LayoutItem))
+	var gap int
+	if g.Gap != nil {
+		value, ok := eval(r, g.Gap).(IntData)
+		if !ok {
+			raise("Grid gap must be IntData in `%s`", g)
+		}
+		gap = value.Value
 	}
 
-	return NewGroup(r.Solver, layoutItems...)
+	var grid Grid
+	solve(r, g.Line, func() {
+		grid = newGrid(r.Solver, r.Constraints, columns.Value, float64(gap), layoutItems...)
+	})
+
+	return grid
 }
 
 type Htmlify struct {
@@ -677,19 +1021,13 @@ func (h Htmlify) Eval(r Runtime) Data {
 </html>
 `
 
-	layout := h.Layout.Eval(r)
-	html := layout.(LayoutItem).AsHtml()
-
-	file, err := os.Create(strings.Trim(h.File, "\"") + ".html")
-	if err != nil {
-		panic("error htmlifying")
+	layout, ok := eval(r, h.Layout).(LayoutItem)
+	if !ok {
+		raise("htmlify called on something that isn't a layout item in `%s`", h)
 	}
-	defer file.Close()
+	html := layout.AsHtml()
 
-	_, err = file.WriteString(top + html + bottom)
-	if err != nil {
-		panic("error writing to file")
-	}
+	writeOutput(r, strings.Trim(h.File, "\"")+".html", []byte(top+html+bottom))
 
 	return NoData{}
 }
@@ -0,0 +1,297 @@
+package backend
+
+import (
+	"fmt"
+	"github.com/lithdew/casso"
+	"strings"
+)
+
+// Grid lays items out in rows of Columns cells, Gap apart. Every cell in a
+// column shares the column's x and width and every cell in a row the row's y
+// and height, so a column is as wide as its widest item and a row as tall as
+// its tallest. Items fill the cells left to right then top to bottom, a Span
+// takes up more than one
+type Grid struct {
+	Cells   []Cell
+	Columns int
+	Rows    int
+	Gap     float64
+	solver  *casso.Solver
+	trace   *ConstraintTrace
+
+	X casso.Symbol
+	Y casso.Symbol
+	H casso.Symbol
+	W casso.Symbol
+
+	// where each column starts and how wide it is, and the same for rows
+	ColumnX, ColumnW []casso.Symbol
+	RowY, RowH       []casso.Symbol
+}
+
+// Cell is where an item was put in a grid, Column and Row are where its top
+// left is and Columns and Rows how many it spans
+type Cell struct {
+	Item          LayoutItem
+	Column, Row   int
+	Columns, Rows int
+}
+
+// Span is an item that takes up more than one cell when it's put in a grid,
+// everywhere else it's just the item
+type Span struct {
+	LayoutItem
+	Columns, Rows int
+}
+
+// maxGridTracks is the most columns or rows a grid can have, each one is
+// variables and constraints in the solver
+const maxGridTracks = 1000
+
+func NewGrid(solver *casso.Solver, columns int, gap float64, items ...LayoutItem) Grid {
+	return newGrid(solver, nil, columns, gap, items...)
+}
+
+// newGrid is NewGrid recording its constraints in trace
+func newGrid(solver *casso.Solver, trace *ConstraintTrace, columns int, gap float64, items ...LayoutItem) Grid {
+	if columns < 1 || columns > maxGridTracks {
+		raise("Grid needs 1 to %d columns got %d", maxGridTracks, columns)
+	}
+
+	grid := Grid{
+		Cells:   place(columns, items),
+		Columns: columns,
+		Gap:     gap,
+		solver:  solver,
+		trace:   trace,
+		X:       casso.New(),
+		Y:       casso.New(),
+		H:       casso.New(),
+		W:       casso.New(),
+	}
+	for _, cell := range grid.Cells {
+		if cell.Row+cell.Rows > grid.Rows {
+			grid.Rows = cell.Row + cell.Rows
+		}
+	}
+	name := trace.item(trace.container("grid"), grid.X, grid.Y, grid.W, grid.H)
+
+	for i := 0; i < grid.Columns; i++ {
+		grid.ColumnX = append(grid.ColumnX, casso.New())
+		grid.ColumnW = append(grid.ColumnW, casso.New())
+		trace.variable(fmt.Sprintf("%s.col%d.x", name, i), grid.ColumnX[i])
+		trace.variable(fmt.Sprintf("%s.col%d.w", name, i), grid.ColumnW[i])
+	}
+	for i := 0; i < grid.Rows; i++ {
+		grid.RowY = append(grid.RowY, casso.New())
+		grid.RowH = append(grid.RowH, casso.New())
+		trace.variable(fmt.Sprintf("%s.row%d.y", name, i), grid.RowY[i])
+		trace.variable(fmt.Sprintf("%s.row%d.h", name, i), grid.RowH[i])
+	}
+
+	add := func(priority casso.Priority, relation string, items []LayoutItem, op casso.Op, constant float64, terms ...Term) {
+		if err := constrainWith(solver, trace, priority, relation, items, op, constant, terms...); err != nil {
+			raise("failed to add Grid %s constraint. err=%v", relation, err)
+		}
+	}
+	self := []LayoutItem{grid}
+
+	// keep the grid in bounds of the screen
+	add(casso.Required, "is on screen", self, casso.GTE, 0, termOf(grid.X, 1))
+	add(casso.Required, "is on screen", self, casso.GTE, 0, termOf(grid.Y, 1))
+
+	// tracks are the columns or the rows, each starts gap after the one
+	// before it and the grid ends with the last one
+	tracks := func(kind string, start, length casso.Symbol, starts, lengths []casso.Symbol) {
+		if len(starts) == 0 {
+			add(casso.Required, "has no "+kind+"s", self, casso.EQ, 0, termOf(length, 1))
+			return
+		}
+
+		for i := range starts {
+			if i == 0 {
+				// first.start = grid.start
+				add(casso.Required, "starts with its first "+kind, self, casso.EQ, 0, termOf(starts[i], 1), termOf(start, -1))
+			} else {
+				// track.start = previous.start + previous.length + gap
+				add(casso.Required, kind+"s are gap apart", self, casso.EQ, -gap,
+					termOf(starts[i], 1), termOf(starts[i-1], -1), termOf(lengths[i-1], -1))
+			}
+
+			// tracks are only as big as their items need
+			add(casso.Weak, kind+" is as small as it can be", self, casso.EQ, 0, termOf(lengths[i], 1))
+		}
+
+		// grid.length = last.start + last.length - grid.start
+		last := len(starts) - 1
+		add(casso.Required, "ends with its last "+kind, self, casso.EQ, 0,
+			termOf(length, 1), termOf(starts[last], -1), termOf(lengths[last], -1), termOf(start, 1))
+	}
+	tracks("column", grid.X, grid.W, grid.ColumnX, grid.ColumnW)
+	tracks("row", grid.Y, grid.H, grid.RowY, grid.RowH)
+
+	for _, cell := range grid.Cells {
+		item := cell.Item
+		pair := []LayoutItem{item, grid}
+
+		// item.x = column.x, item.y = row.y
+		add(casso.Required, fmt.Sprintf("is in column %d of", cell.Column), pair, casso.EQ, 0,
+			termOf(item.GetX(), 1), termOf(grid.ColumnX[cell.Column], -1))
+		add(casso.Required, fmt.Sprintf("is in row %d of", cell.Row), pair, casso.EQ, 0,
+			termOf(item.GetY(), 1), termOf(grid.RowY[cell.Row], -1))
+
+		// the columns it spans and the gaps between them are at least as
+		// wide as the item, and the same for rows
+		var width, height []Term
+		for i := cell.Column; i < cell.Column+cell.Columns; i++ {
+			width = append(width, termOf(grid.ColumnW[i], 1))
+		}
+		for i := cell.Row; i < cell.Row+cell.Rows; i++ {
+			height = append(height, termOf(grid.RowH[i], 1))
+		}
+		add(casso.Required, "fits in its columns of", pair, casso.GTE, float64(cell.Columns-1)*gap, append(width, termOf(item.GetW(), -1))...)
+		add(casso.Required, "fits in its rows of", pair, casso.GTE, float64(cell.Rows-1)*gap, append(height, termOf(item.GetH(), -1))...)
+	}
+
+	return grid
+}
+
+// place puts items in the first cells they fit in, left to right then top to
+// bottom, never going back to fill gaps left before the last item
+func place(columns int, items []LayoutItem) []Cell {
+	taken := map[[2]int]bool{}
+	fits := func(column, row, columns, rows int) bool {
+		for r := row; r < row+rows; r++ {
+			for c := column; c < column+columns; c++ {
+				if taken[[2]int{c, r}] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	var cells []Cell
+	column, row := 0, 0
+	for _, item := range items {
+		cell := Cell{Item: item, Columns: 1, Rows: 1}
+		if span, ok := item.(Span); ok {
+			cell = Cell{Item: span.LayoutItem, Columns: span.Columns, Rows: span.Rows}
+		}
+		if cell.Columns < 1 || cell.Rows < 1 {
+			raise("Grid cells span at least 1 column and row got %dx%d", cell.Columns, cell.Rows)
+		}
+		if cell.Columns > columns {
+			raise("Grid item spans %d columns but the grid only has %d", cell.Columns, columns)
+		}
+
+		for column+cell.Columns > columns || !fits(column, row, cell.Columns, cell.Rows) {
+			column++
+			if column+cell.Columns > columns {
+				column = 0
+				row++
+			}
+		}
+		if row+cell.Rows > maxGridTracks {
+			raise("Grid can't have more than %d rows", maxGridTracks)
+		}
+
+		cell.Column, cell.Row = column, row
+		for r := row; r < row+cell.Rows; r++ {
+			for c := column; c < column+cell.Columns; c++ {
+				taken[[2]int{c, r}] = true
+			}
+		}
+		cells = append(cells, cell)
+		column += cell.Columns
+	}
+
+	return cells
+}
+
+// Items are the items in the grid's cells
+func (g Grid) Items() []LayoutItem {
+	var items []LayoutItem
+	for _, cell := range g.Cells {
+		items = append(items, cell.Item)
+	}
+	return items
+}
+
+func (g Grid) RightEdge() float64 { return g.solver.Val(g.X) + g.solver.Val(g.W) }
+func (g Grid) LeftEdge() float64  { return g.solver.Val(g.X) }
+func (g Grid) Top() float64       { return g.solver.Val(g.Y) }
+func (g Grid) Bottom() float64    { return g.solver.Val(g.Y) + g.solver.Val(g.H) }
+func (g Grid) GetX() casso.Symbol { return g.X }
+func (g Grid) GetY() casso.Symbol { return g.Y }
+func (g Grid) GetW() casso.Symbol { return g.W }
+func (g Grid) GetH() casso.Symbol { return g.H }
+
+func (g Grid) AsHtml() string {
+	var sb strings.Builder
+
+	sb.WriteString("<div>")
+
+	for _, cell := range g.Cells {
+		sb.WriteString(cell.Item.AsHtml())
+	}
+
+	sb.WriteString("</div>")
+
+	return sb.String()
+}
+
+func (g Grid) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("GRID{X:%.2f Y:%.2f W:%.2f H:%.2f COLUMNS:%d ROWS:%d",
+		g.solver.Val(g.X),
+		g.solver.Val(g.Y),
+		g.solver.Val(g.W),
+		g.solver.Val(g.H),
+		g.Columns,
+		g.Rows,
+	))
+
+	sb.WriteString("\nCHILDREN:{\n")
+
+	for _, cell := range g.Cells {
+		sb.WriteString(fmt.Sprintf("\t%d,%d %dx%d %s", cell.Column, cell.Row, cell.Columns, cell.Rows, cell.Item.String()))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("}}")
+
+	return sb.String()
+}
+
+func (g Grid) IsLeftOf(item LayoutItem) {
+	// g.X + g.W <= item.x
+	err := constrain(g.solver, g.trace, "is left of", []LayoutItem{g, item}, casso.LTE, 0, termOf(g.X, 1), termOf(g.W, 1), termOf(item.GetX(), -1))
+	if err != nil {
+		raise("failed to add Grid IsLeftOf constraint. err=%v", err)
+	}
+}
+
+func (g Grid) IsRightOf(item LayoutItem) {
+	// g.X >= item.x + item.W
+	err := constrain(g.solver, g.trace, "is right of", []LayoutItem{g, item}, casso.GTE, 0, termOf(g.X, 1), termOf(item.GetX(), -1), termOf(item.GetW(), -1))
+	if err != nil {
+		raise("failed to add Grid IsRightOf constraint. err=%v", err)
+	}
+}
+
+func (g Grid) IsBelow(item LayoutItem) {
+	// g.Y >= item.Y + item.H
+	err := constrain(g.solver, g.trace, "is below", []LayoutItem{g, item}, casso.GTE, 0, termOf(g.Y, 1), termOf(item.GetY(), -1), termOf(item.GetH(), -1))
+	if err != nil {
+		raise("failed to add Grid IsBelow constraint. err=%v", err)
+	}
+}
+
+func (g Grid) IsAbove(item LayoutItem) {
+	// g.Y + g.H <= item.Y
+	err := constrain(g.solver, g.trace, "is above", []LayoutItem{g, item}, casso.LTE, 0, termOf(g.Y, 1), termOf(g.H, 1), termOf(item.GetY(), -1))
+	if err != nil {
+		raise("failed to add Grid IsAbove constraint. err=%v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package backend
+
+import (
+	"fmt"
+)
+
+// normalizeIndex checks pos is an IntData inside a collection of length,
+// negative positions count back from the end and extra allows positions past
+// the end (inserting or slicing up to length)
+func normalizeIndex(pos Data, length int, name string, extra int) int {
+	posInt, ok := pos.(IntData)
+	if !ok {
+		raise("%s position must be IntData got %s", name, pos)
+	}
+
+	index := posInt.Value
+	if index < 0 {
+		index += length
+	}
+
+	if index < 0 || index >= length+extra {
+		raise("%s position %d out of range for length %d", name, posInt.Value, length)
+	}
+
+	return index
+}
+
+// Index is value[position] for lists, strings and maps
+type Index struct {
+	Target   Expression
+	Position Expression
+}
+
+func (i Index) String() string {
+	return fmt.Sprintf("%s[%s]", i.Target, i.Position)
+}
+
+func (i Index) Eval(r Runtime) Data {
+	return i.apply(eval(r, i.Target), eval(r, i.Position))
+}
+
+func (i Index) apply(target, position Data) Data {
+	switch target := target.(type) {
+	case ListData:
+		return target.Values[normalizeIndex(position, len(target.Values), "index", 0)]
+	case StringData:
+		runes := []rune(target.Value)
+		char := string(runes[normalizeIndex(position, len(runes), "index", 0)])
+		return StringData{Value: char, Literal: fmt.Sprintf("%q", char)}
+	case MapData:
+		key := mapKey(position)
+		value, found := target.Values[key]
+		if !found {
+			raise("key %q not in map", key)
+		}
+		return value
+	default:
+		raise("attempt to index %T in `%s`", target, i)
+		return NoData{}
+	}
+}
+
+// Slice is value[start:stop] for lists and strings, either bound can be left out
+type Slice struct {
+	Target Expression
+	Start  Expression // nil means from the beginning
+	Stop   Expression // nil means to the end
+}
+
+func (s Slice) String() string {
+	var start, stop string
+	if s.Start != nil {
+		start = s.Start.String()
+	}
+	if s.Stop != nil {
+		stop = s.Stop.String()
+	}
+
+	return fmt.Sprintf("%s[%s:%s]", s.Target, start, stop)
+}
+
+// bounds evaluates the slice range for a collection of length
+func (s Slice) bounds(r Runtime, length int) (int, int) {
+	start, stop := 0, length
+	if s.Start != nil {
+		start = normalizeIndex(eval(r, s.Start), length, "slice", 1)
+	}
+	if s.Stop != nil {
+		stop = normalizeIndex(eval(r, s.Stop), length, "slice", 1)
+	}
+	if start > stop {
+		raise("slice start %d is after stop %d", start, stop)
+	}
+
+	return start, stop
+}
+
+func (s Slice) Eval(r Runtime) Data {
+	switch target := eval(r, s.Target).(type) {
+	case ListData:
+		start, stop := s.bounds(r, len(target.Values))
+
+		var newList ListData
+		newList.Values = append(newList.Values, target.Values[start:stop]...)
+		return newList
+	case StringData:
+		runes := []rune(target.Value)
+		start, stop := s.bounds(r, len(runes))
+
+		value := string(runes[start:stop])
+		return StringData{Value: value, Literal: fmt.Sprintf("%q", value)}
+	default:
+		raise("attempt to slice %T in `%s`", target, s)
+		return NoData{}
+	}
+}
+
+// IndexAssign is value[position] = expr
+type IndexAssign struct {
+	Target   Expression
+	Position Expression
+	Value    Expression
+}
+
+func (ia IndexAssign) String() string {
+	return fmt.Sprintf("%s[%s] = %s", ia.Target, ia.Position, ia.Value)
+}
+
+func (ia IndexAssign) Eval(r Runtime) Data {
+	value := eval(r, ia.Value)
+	position := eval(r, ia.Position)
+	place := resolve(r, ia.Target)
+
+	var updated Data
+	switch target := eval(r, place).(type) {
+	case ListData:
+		index := normalizeIndex(position, len(target.Values), "index", 0)
+
+		var newList ListData
+		newList.Values = append(newList.Values, target.Values...)
+		newList.Values[index] = value
+		updated = newList
+	case MapData:
+		updated = target.Set(mapKey(position), value)
+	default:
+		raise("can't assign to an index of %T in `%s`", target, ia)
+	}
+
+	assignTo(r, place, updated)
+
+	return NoData{}
+}
+
+// Lists, maps and records are values: nothing is ever changed in place, every
+// operation builds a new value. Changing one (x[0] = 1, x.field = 1 or a
+// method like x.add(1)) stores the new value back into the place it came
+// from, so aliases and function arguments never see each others changes.
+// Mutating methods called on something that isn't a place, like f().add(1),
+// just return the changed copy.
+
+// assignable is true if target names a place assignTo can store into
+func assignable(target Expression) bool {
+	switch target := target.(type) {
+	case Dereference:
+		return true
+	case Index:
+		return assignable(target.Target)
+	case FieldAccess:
+		return assignable(target.Receiver)
+	default:
+		return false
+	}
+}
+
+// resolve evaluates the positions of the indexes in target, so the place it
+// names is read and assigned back to at the same indexes even when working
+// out a position has side effects, like xs[next()].add(1)
+func resolve(r Runtime, target Expression) Expression {
+	switch target := target.(type) {
+	case Index:
+		inner := resolve(r, target.Target)
+		return Index{Target: inner, Position: evaluated{eval(r, target.Position)}}
+	case FieldAccess:
+		return FieldAccess{Receiver: resolve(r, target.Receiver), Field: target.Field}
+	default:
+		return target
+	}
+}
+
+// assignTo stores value back into the place target refers to, for nested
+// targets like grid[0].items[1] the containers are rebuilt from the inside out.
+// target should be resolved so its positions aren't evaluated again
+func assignTo(r Runtime, target Expression, value Data) {
+	switch target := target.(type) {
+	case Dereference:
+		if _, ok := r.SymbolTable[target.Name]; !ok {
+			raise("Attempt to assign to uninitialized variable %s", target.Name)
+		}
+		r.SymbolTable[target.Name] = value
+	case Index:
+		IndexAssign{
+			Target:   target.Target,
+			Position: target.Position,
+			Value:    evaluated{value},
+		}.Eval(r)
+	case FieldAccess:
+		FieldAssign{
+			Receiver: target.Receiver,
+			Field:    target.Field,
+			Value:    evaluated{value},
+		}.Eval(r)
+	default:
+		raise("can't assign to `%s`", target)
+	}
+}
+
+// evaluated wraps an already computed value so it can be passed where an
+// Expression is expected
+type evaluated struct {
+	Data
+}
+
+func (e evaluated) Eval(r Runtime) Data { return e.Data }
@@ -28,6 +28,7 @@ type LayoutItem interface {
 
 type Box struct {
 	solver *casso.Solver
+	trace  *ConstraintTrace
 	Id     string
 
 	CX casso.Symbol
@@ -37,26 +38,28 @@ type Box struct {
 }
 
 func NewBox(s *casso.Solver, id string) Box {
-	// all boxes default 50x50 might add size params later
-	// width constraint
-	bw := casso.New()
-	casso.NewConstraint(casso.EQ, -50, bw.T(1))
-	s.AddConstraint(casso.NewConstraint(casso.EQ, -50, bw.T(1)))
-
-	// height constraint
-	bh := casso.New()
-	casso.NewConstraint(casso.EQ, -50, bh.T(1))
-	s.AddConstraint(casso.NewConstraint(casso.EQ, -50, bh.T(1)))
-
-	bx, by := casso.New(), casso.New()
-	return Box{
+	return newBox(s, nil, id)
+}
+
+// newBox is NewBox recording its constraints in trace
+func newBox(s *casso.Solver, trace *ConstraintTrace, id string) Box {
+	bx, by, bw, bh := casso.New(), casso.New(), casso.New(), casso.New()
+	box := Box{
 		solver: s,
+		trace:  trace,
 		Id:     id,
 		CX:     bx,
 		CY:     by,
 		CW:     bw,
 		CH:     bh,
 	}
+	trace.item(strings.Trim(id, `"`), bx, by, bw, bh)
+
+	// all boxes default 50x50 might add size params later
+	constrain(s, trace, "width", []LayoutItem{box}, casso.EQ, -50, termOf(bw, 1))
+	constrain(s, trace, "height", []LayoutItem{box}, casso.EQ, -50, termOf(bh, 1))
+
+	return box
 }
 
 func (b Box) GetX() casso.Symbol { return b.CX }
@@ -98,39 +101,40 @@ func (b Box) String() string {
 
 func (b Box) IsLeftOf(item LayoutItem) {
 	// b.X + b.W <= item.x
-	_, err := b.solver.AddConstraint(casso.NewConstraint(casso.LTE, 0, b.CX.T(1), b.CW.T(1), item.GetX().T(-1)))
+	err := constrain(b.solver, b.trace, "is left of", []LayoutItem{b, item}, casso.LTE, 0, termOf(b.CX, 1), termOf(b.CW, 1), termOf(item.GetX(), -1))
 	if err != nil {
-		panic(fmt.Sprintf("failed to add IsLeftOf constraint. err=%v", err))
+		raise("failed to add IsLeftOf constraint. err=%v", err)
 	}
 }
 
 func (b Box) IsRightOf(item LayoutItem) {
 	// b.X >= item.x + item.W
-	_, err := b.solver.AddConstraint(casso.NewConstraint(casso.GTE, 0, b.CX.T(1), item.GetX().T(-1), item.GetW().T(-1)))
+	err := constrain(b.solver, b.trace, "is right of", []LayoutItem{b, item}, casso.GTE, 0, termOf(b.CX, 1), termOf(item.GetX(), -1), termOf(item.GetW(), -1))
 	if err != nil {
-		panic(fmt.Sprintf("failed to add IsRightOf constraint. err=%v", err))
+		raise("failed to add IsRightOf constraint. err=%v", err)
 	}
 }
 
 func (b Box) IsAbove(item LayoutItem) {
 	// b.Y + b.H <= item.Y
-	_, err := b.solver.AddConstraint(casso.NewConstraint(casso.LTE, 0, b.CY.T(1), b.CH.T(1), item.GetY().T(-1)))
+	err := constrain(b.solver, b.trace, "is above", []LayoutItem{b, item}, casso.LTE, 0, termOf(b.CY, 1), termOf(b.CH, 1), termOf(item.GetY(), -1))
 	if err != nil {
-		panic(fmt.Sprintf("failed to add IsAbove constraint. err=%v", err))
+		raise("failed to add IsAbove constraint. err=%v", err)
 	}
 }
 
 func (b Box) IsBelow(item LayoutItem) {
 	// b.Y >= item.Y + item.H
-	_, err := b.solver.AddConstraint(casso.NewConstraint(casso.GTE, 0, b.CY.T(1), item.GetY().T(-1), item.GetH().T(-1)))
+	err := constrain(b.solver, b.trace, "is below", []LayoutItem{b, item}, casso.GTE, 0, termOf(b.CY, 1), termOf(item.GetY(), -1), termOf(item.GetH(), -1))
 	if err != nil {
-		panic(fmt.Sprintf("failed to add IsBelow constraint. err=%v", err))
+		raise("failed to add IsBelow constraint. err=%v", err)
 	}
 }
 
 type Group struct {
 	Items  []LayoutItem
 	solver *casso.Solver
+	trace  *ConstraintTrace
 
 	X casso.Symbol
 	Y casso.Symbol
@@ -162,6 +166,11 @@ func (g Group) AsHtml() string {
 }
 
 func NewGroup(solver *casso.Solver, items ...LayoutItem) Group {
+	return newGroup(solver, nil, items...)
+}
+
+// newGroup is NewGroup recording its constraints in trace
+func newGroup(solver *casso.Solver, trace *ConstraintTrace, items ...LayoutItem) Group {
 	var maxX, maxY, minX, minY float64
 
 	for _, item := range items {
@@ -188,34 +197,37 @@ func NewGroup(solver *casso.Solver, items ...LayoutItem) Group {
 	groupW := casso.New()
 	groupH := casso.New()
 
+	hold := Group{
+		Items:  items,
+		solver: solver,
+		trace:  trace,
+		X:      groupX,
+		Y:      groupY,
+		H:      groupH,
+		W:      groupW,
+	}
+	trace.item(trace.container("group"), groupX, groupY, groupW, groupH)
+	group := []LayoutItem{hold}
+
 	// groupX = min_x
-	solver.AddConstraint(casso.NewConstraint(casso.GTE, -1*minX, groupX.T(1)))
+	constrain(solver, trace, "starts at its items' left", group, casso.GTE, -1*minX, termOf(groupX, 1))
 	// groupY = min_y
-	solver.AddConstraint(casso.NewConstraint(casso.GTE, -1*minY, groupY.T(1)))
+	constrain(solver, trace, "starts at its items' top", group, casso.GTE, -1*minY, termOf(groupY, 1))
 	// groupW = min_x - max_x max-min 100 -50 = w
-	solver.AddConstraint(casso.NewConstraint(casso.GTE, -1*(maxX-minX), groupW.T(1)))
+	constrain(solver, trace, "is as wide as its items", group, casso.GTE, -1*(maxX-minX), termOf(groupW, 1))
 	// groupH = min_y - max_y
-	solver.AddConstraint(casso.NewConstraint(casso.GTE, -1*(maxY-minY), groupH.T(1)))
+	constrain(solver, trace, "is as tall as its items", group, casso.GTE, -1*(maxY-minY), termOf(groupH, 1))
 
 	// keep the group in bounds of the screen
-	solver.AddConstraint(casso.NewConstraint(casso.GTE, 0, groupX.T(1)))
-	solver.AddConstraint(casso.NewConstraint(casso.GTE, 0, groupY.T(1)))
+	constrain(solver, trace, "is on screen", group, casso.GTE, 0, termOf(groupX, 1))
+	constrain(solver, trace, "is on screen", group, casso.GTE, 0, termOf(groupY, 1))
 
 	// when we do a group, each child gets a new constraint applied to its X/Y
 	// they both must be greater than or equal to the parent's x ans y
 	for _, item := range items {
 		// items X and Y must be >= the min of the groups X/Y
-		solver.AddConstraint(casso.NewConstraint(casso.GTE, 0, item.GetX().T(1), groupX.T(-1)))
-		solver.AddConstraint(casso.NewConstraint(casso.GTE, 0, item.GetY().T(1), groupY.T(-1)))
-	}
-
-	hold := Group{
-		Items:  items,
-		solver: solver,
-		X:      groupX,
-		Y:      groupY,
-		H:      groupH,
-		W:      groupW,
+		constrain(solver, trace, "contains", []LayoutItem{hold, item}, casso.GTE, 0, termOf(item.GetX(), 1), termOf(groupX, -1))
+		constrain(solver, trace, "contains", []LayoutItem{hold, item}, casso.GTE, 0, termOf(item.GetY(), 1), termOf(groupY, -1))
 	}
 
 	return hold
@@ -244,34 +256,34 @@ func (g Group) String() string {
 
 func (g Group) IsLeftOf(item LayoutItem) {
 	// b.X + b.W <= item.x
-	_, err := g.solver.AddConstraint(casso.NewConstraint(casso.LTE, 0, g.X.T(1), g.W.T(1), item.GetX().T(-1)))
+	err := constrain(g.solver, g.trace, "is left of", []LayoutItem{g, item}, casso.LTE, 0, termOf(g.X, 1), termOf(g.W, 1), termOf(item.GetX(), -1))
 
 	if err != nil {
-		panic(fmt.Sprintf("failed to add Group IsLeftOf constraint. err=%v", err))
+		raise("failed to add Group IsLeftOf constraint. err=%v", err)
 	}
 }
 
 func (g Group) IsRightOf(item LayoutItem) {
 	// b.X >= item.x + item.W
-	_, err := g.solver.AddConstraint(casso.NewConstraint(casso.GTE, 0, g.X.T(1), item.GetX().T(-1), item.GetW().T(-1)))
+	err := constrain(g.solver, g.trace, "is right of", []LayoutItem{g, item}, casso.GTE, 0, termOf(g.X, 1), termOf(item.GetX(), -1), termOf(item.GetW(), -1))
 
 	if err != nil {
-		panic(fmt.Sprintf("failed to add Group IsRightOf constraint. err=%v", err))
+		raise("failed to add Group IsRightOf constraint. err=%v", err)
 	}
 }
 
 func (g Group) IsBelow(item LayoutItem) {
 	// b.Y >= item.Y + item.H
-	_, err := g.solver.AddConstraint(casso.NewConstraint(casso.GTE, 0, g.Y.T(1), item.GetY().T(-1), item.GetH().T(-1)))
+	err := constrain(g.solver, g.trace, "is below", []LayoutItem{g, item}, casso.GTE, 0, termOf(g.Y, 1), termOf(item.GetY(), -1), termOf(item.GetH(), -1))
 	if err != nil {
-		panic(fmt.Sprintf("failed to add Group IsBelow constraint. err=%v", err))
+		raise("failed to add Group IsBelow constraint. err=%v", err)
 	}
 }
 
 func (g Group) IsAbove(item LayoutItem) {
 	// b.Y + b.H <= item.Y
-	_, err := g.solver.AddConstraint(casso.NewConstraint(casso.LTE, 0, g.GetY().T(1), item.GetH().T(1), item.GetY().T(-1)))
+	err := constrain(g.solver, g.trace, "is above", []LayoutItem{g, item}, casso.LTE, 0, termOf(g.GetY(), 1), termOf(item.GetH(), 1), termOf(item.GetY(), -1))
 	if err != nil {
-		panic(fmt.Sprintf("failed to add Group IsAbove constraint. err=%v", err))
+		raise("failed to add Group IsAbove constraint. err=%v", err)
 	}
 }
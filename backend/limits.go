@@ -0,0 +1,141 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrCanceled   = errors.New("program canceled")
+	ErrTimeout    = errors.New("program timed out")
+	ErrStepLimit  = errors.New("step limit exceeded")
+	ErrCallDepth  = errors.New("call depth limit exceeded")
+	ErrListSize   = errors.New("list size limit exceeded")
+	ErrStringSize = errors.New("string size limit exceeded")
+)
+
+// Limits bound the work a program can do, zero values mean no limit
+type Limits struct {
+	MaxSteps      int // expressions evaluated
+	MaxCallDepth  int // nested function calls, replaces the runtime's MaxCallDepth
+	MaxListSize   int // elements in a single list
+	MaxStringSize int // bytes in a single string, strings never go over maxStringLength
+	Timeout       time.Duration
+}
+
+// contextCheckInterval is how many steps run between checks of the context,
+// checking it on every step is needlessly slow
+const contextCheckInterval = 256
+
+// budget tracks a running program against its limits, it's shared by every
+// scope of a runtime
+type budget struct {
+	ctx    context.Context
+	limits Limits
+	steps  int
+}
+
+// WithLimits returns a runtime that stops with an error wrapping one of the
+// Err* values once ctx is done or a limit is hit, cancel releases the timeout
+// and should be called when the program is done
+func (r Runtime) WithLimits(ctx context.Context, limits Limits) (Runtime, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if limits.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+	}
+
+	r.budget = &budget{ctx: ctx, limits: limits}
+	if limits.MaxCallDepth > 0 {
+		r.MaxCallDepth = limits.MaxCallDepth
+	}
+	if r.calls == nil {
+		r.calls = &callStack{}
+	}
+	return r, cancel
+}
+
+// limitExceeded aborts evaluation with a RuntimeError wrapping err
+func limitExceeded(err error, format string, args ...any) {
+	panic(RuntimeError{Message: fmt.Sprintf("%s: %s", err, fmt.Sprintf(format, args...)), Err: err})
+}
+
+func (b *budget) step() {
+	b.steps++
+	if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+		limitExceeded(ErrStepLimit, "ran more than %d steps", b.limits.MaxSteps)
+	}
+
+	if b.steps%contextCheckInterval == 1 {
+		switch b.ctx.Err() {
+		case nil:
+		case context.DeadlineExceeded:
+			limitExceeded(ErrTimeout, "stopped after %d steps", b.steps)
+		default:
+			limitExceeded(ErrCanceled, "stopped after %d steps", b.steps)
+		}
+	}
+}
+
+// eval evaluates a sub expression, everything goes through here so limits
+// and hooks apply to the whole program
+func eval(r Runtime, e Expression) Data {
+	return evalAt(r, e, Position{})
+}
+
+// evalAt is eval for an expression that starts at pos in the source
+func evalAt(r Runtime, e Expression, pos Position) Data {
+	if r.budget != nil {
+		r.budget.step()
+	}
+
+	if r.Hook == nil {
+		return e.Eval(r)
+	}
+	if pos.Line > 0 {
+		pos.File = r.File
+	}
+
+	r.Hook.Before(r, e, pos)
+	result := e.Eval(r)
+	r.Hook.After(r, e, pos, result)
+
+	return result
+}
+
+// checkListSize raises if a list of size elements would go over the limit
+func checkListSize(r Runtime, size int) {
+	if r.budget != nil && r.budget.limits.MaxListSize > 0 && size > r.budget.limits.MaxListSize {
+		limitExceeded(ErrListSize, "list of %d elements is bigger than %d", size, r.budget.limits.MaxListSize)
+	}
+}
+
+// checkStringSize raises if a string of size bytes would go over the limit,
+// or the hard cap for runtimes without one
+func checkStringSize(r Runtime, size int) {
+	limit := maxStringLength
+	if r.budget != nil && r.budget.limits.MaxStringSize > 0 && r.budget.limits.MaxStringSize < limit {
+		limit = r.budget.limits.MaxStringSize
+	}
+	if size > limit {
+		limitExceeded(ErrStringSize, "string of %d bytes is bigger than %d", size, limit)
+	}
+}
+
+// checkNative checks what a native function returned against the limits,
+// natives don't get the runtime so what they build is checked afterwards.
+// Their arguments are within the limits, which bounds what they can build
+func checkNative(r Runtime, result Data) {
+	switch result := result.(type) {
+	case StringData:
+		checkStringSize(r, len(result.Value))
+	case ListData:
+		checkListSize(r, len(result.Values))
+		for _, value := range result.Values {
+			if s, ok := value.(StringData); ok {
+				checkStringSize(r, len(s.Value))
+			}
+		}
+	}
+}
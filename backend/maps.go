@@ -0,0 +1,42 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Map struct {
+	Keys   []Expression
+	Values []Expression
+}
+
+func (m Map) String() string {
+	var sb strings.Builder
+
+	sb.WriteString("{ ")
+	for i := range m.Keys {
+		sb.WriteString(fmt.Sprintf("%s: %s ", m.Keys[i], m.Values[i]))
+	}
+	sb.WriteString("}")
+
+	return sb.String()
+}
+
+func (m Map) Eval(r Runtime) Data {
+	mapData := NewMapData()
+	for i := range m.Keys {
+		mapData = mapData.Set(mapKey(eval(r, m.Keys[i])), eval(r, m.Values[i]))
+	}
+
+	return mapData
+}
+
+// mapKey checks a value used as a key, keys are always strings
+func mapKey(key Data) string {
+	keyString, ok := key.(StringData)
+	if !ok {
+		raise("map keys must be StringData, got %s", key)
+	}
+
+	return keyString.Value
+}
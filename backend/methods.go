@@ -0,0 +1,322 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// method is a builtin operation on a value of type T, called as value.name(args)
+type method[T Data] struct {
+	MinArgs int
+	MaxArgs int
+	// Mutates methods store their result back into the receiver when it's
+	// assignable, so value.add(1) updates value (see assignTo)
+	Mutates bool
+	Fn      func(r Runtime, receiver T, args []Data) Data
+}
+
+var listMethods = map[string]method[ListData]{
+	"get": {MinArgs: 1, MaxArgs: 1, Fn: func(r Runtime, list ListData, args []Data) Data {
+		return list.Values[listIndex(list, args[0], "get", 0)]
+	}},
+	"add": {MinArgs: 1, MaxArgs: 1, Mutates: true, Fn: func(r Runtime, list ListData, args []Data) Data {
+		checkListSize(r, len(list.Values)+1)
+
+		var newList ListData
+		newList.Values = append(newList.Values, list.Values...)
+		newList.Values = append(newList.Values, args[0])
+		return newList
+	}},
+	"del": {MinArgs: 1, MaxArgs: 1, Mutates: true, Fn: func(r Runtime, list ListData, args []Data) Data {
+		pos := listIndex(list, args[0], "del", 0)
+
+		var newList ListData
+		newList.Values = append(newList.Values, list.Values[:pos]...)
+		newList.Values = append(newList.Values, list.Values[pos+1:]...)
+		return newList
+	}},
+	"insert": {MinArgs: 2, MaxArgs: 2, Mutates: true, Fn: func(r Runtime, list ListData, args []Data) Data {
+		// inserting at len appends
+		pos := listIndex(list, args[0], "insert", 1)
+		checkListSize(r, len(list.Values)+1)
+
+		var newList ListData
+		newList.Values = append(newList.Values, list.Values[:pos]...)
+		newList.Values = append(newList.Values, args[1])
+		newList.Values = append(newList.Values, list.Values[pos:]...)
+		return newList
+	}},
+	"set": {MinArgs: 2, MaxArgs: 2, Mutates: true, Fn: func(r Runtime, list ListData, args []Data) Data {
+		pos := listIndex(list, args[0], "set", 0)
+
+		var newList ListData
+		newList.Values = append(newList.Values, list.Values...)
+		newList.Values[pos] = args[1]
+		return newList
+	}},
+	"slice": {MinArgs: 2, MaxArgs: 2, Fn: func(r Runtime, list ListData, args []Data) Data {
+		start := listIndex(list, args[0], "slice", 1)
+		stop := listIndex(list, args[1], "slice", 1)
+		if start > stop {
+			raise("slice start %d is after stop %d", start, stop)
+		}
+
+		var newList ListData
+		newList.Values = append(newList.Values, list.Values[start:stop]...)
+		return newList
+	}},
+	"reverse": {Fn: func(r Runtime, list ListData, args []Data) Data {
+		var newList ListData
+		for i := len(list.Values) - 1; i >= 0; i-- {
+			newList.Values = append(newList.Values, list.Values[i])
+		}
+		return newList
+	}},
+	"sort": {MinArgs: 0, MaxArgs: 1, Fn: func(r Runtime, list ListData, args []Data) Data {
+		var newList ListData
+		newList.Values = append(newList.Values, list.Values...)
+
+		less := lessData
+		if len(args) == 1 {
+			// custom comparison, sort(f) where f(a, b) is true if a goes first
+			less = func(a, b Data) bool {
+				return boolResult("sort", call(r, "sort", args[0], []Data{a, b}))
+			}
+		}
+
+		sort.SliceStable(newList.Values, func(i, j int) bool {
+			return less(newList.Values[i], newList.Values[j])
+		})
+		return newList
+	}},
+	"contains": {MinArgs: 1, MaxArgs: 1, Fn: func(r Runtime, list ListData, args []Data) Data {
+		for _, value := range list.Values {
+			if Equal(value, args[0]) {
+				return BooleanData{Value: true, Literal: "true"}
+			}
+		}
+		return BooleanData{Value: false, Literal: "false"}
+	}},
+	"index_of": {MinArgs: 1, MaxArgs: 1, Fn: func(r Runtime, list ListData, args []Data) Data {
+		for i, value := range list.Values {
+			if Equal(value, args[0]) {
+				return IntData{Value: i, Literal: fmt.Sprintf("%d", i)}
+			}
+		}
+		return IntData{Value: -1, Literal: "-1"}
+	}},
+	"concat": {MinArgs: 1, MaxArgs: 1, Fn: func(r Runtime, list ListData, args []Data) Data {
+		other, ok := args[0].(ListData)
+		if !ok {
+			raise("concat expects ListData got %s", args[0])
+		}
+		checkListSize(r, len(list.Values)+len(other.Values))
+
+		var newList ListData
+		newList.Values = append(newList.Values, list.Values...)
+		newList.Values = append(newList.Values, other.Values...)
+		return newList
+	}},
+	"map": {MinArgs: 1, MaxArgs: 1, Fn: func(r Runtime, list ListData, args []Data) Data {
+		var newList ListData
+		for _, value := range list.Values {
+			newList.Values = append(newList.Values, call(r, "map", args[0], []Data{value}))
+		}
+		return newList
+	}},
+	"filter": {MinArgs: 1, MaxArgs: 1, Fn: func(r Runtime, list ListData, args []Data) Data {
+		var newList ListData
+		for _, value := range list.Values {
+			if boolResult("filter", call(r, "filter", args[0], []Data{value})) {
+				newList.Values = append(newList.Values, value)
+			}
+		}
+		return newList
+	}},
+	"reduce": {MinArgs: 2, MaxArgs: 2, Fn: func(r Runtime, list ListData, args []Data) Data {
+		// reduce(f, initial) where f(accumulator, value)
+		acc := args[1]
+		for _, value := range list.Values {
+			acc = call(r, "reduce", args[0], []Data{acc, value})
+		}
+		return acc
+	}},
+}
+
+var mapMethods = map[string]method[MapData]{
+	"get": {MinArgs: 1, MaxArgs: 1, Fn: func(r Runtime, m MapData, args []Data) Data {
+		key := mapKey(args[0])
+		value, found := m.Values[key]
+		if !found {
+			raise("key %q not in map", key)
+		}
+		return value
+	}},
+	"set": {MinArgs: 2, MaxArgs: 2, Mutates: true, Fn: func(r Runtime, m MapData, args []Data) Data {
+		return m.Set(mapKey(args[0]), args[1])
+	}},
+	"has": {MinArgs: 1, MaxArgs: 1, Fn: func(r Runtime, m MapData, args []Data) Data {
+		_, found := m.Values[mapKey(args[0])]
+		return BooleanData{Value: found, Literal: fmt.Sprintf("%t", found)}
+	}},
+	"del": {MinArgs: 1, MaxArgs: 1, Mutates: true, Fn: func(r Runtime, m MapData, args []Data) Data {
+		return m.Delete(mapKey(args[0]))
+	}},
+}
+
+// listIndex checks pos is a valid (possibly negative) position in list, see normalizeIndex
+func listIndex(list ListData, pos Data, name string, extra int) int {
+	return normalizeIndex(pos, len(list.Values), name, extra)
+}
+
+func boolResult(name string, result Data) bool {
+	boolean, ok := result.(BooleanData)
+	if !ok {
+		raise("%s function must return BooleanData got %s", name, result)
+	}
+
+	return boolean.Value
+}
+
+// lessData is the default ordering used by sort, ints and strings only
+func lessData(a, b Data) bool {
+	switch a := a.(type) {
+	case IntData:
+		if b, ok := b.(IntData); ok {
+			return a.Value < b.Value
+		}
+	case StringData:
+		if b, ok := b.(StringData); ok {
+			return a.Value < b.Value
+		}
+	}
+
+	raise("can't order %s and %s", a, b)
+	return false
+}
+
+func invoke[T Data](r Runtime, methods map[string]method[T], receiver T, name string, args []Data) (Data, bool) {
+	m, ok := methods[name]
+	if !ok {
+		raise("%T has no method %s", receiver, name)
+	}
+	if len(args) < m.MinArgs || len(args) > m.MaxArgs {
+		expected := fmt.Sprint(m.MaxArgs)
+		if m.MinArgs != m.MaxArgs {
+			expected = fmt.Sprintf("%d to %d", m.MinArgs, m.MaxArgs)
+		}
+		raise("method %s expects %s args got %d", name, expected, len(args))
+	}
+
+	return m.Fn(r, receiver, args), m.Mutates
+}
+
+// MethodCall is any value.name(args), methods chain since the receiver can
+// be any expression [1,2,3].del(2).add(3).len
+type MethodCall struct {
+	Receiver Expression
+	Name     string
+	Args     []Expression
+}
+
+func (mc MethodCall) String() string {
+	var args []string
+	for _, arg := range mc.Args {
+		args = append(args, arg.String())
+	}
+
+	return fmt.Sprintf("%s.%s(%s)", mc.Receiver, mc.Name, strings.Join(args, ", "))
+}
+
+func (mc MethodCall) Eval(r Runtime) Data {
+	place := mc.Receiver
+	if assignable(place) {
+		place = resolve(r, place)
+	}
+	receiver := eval(r, place)
+
+	var args []Data
+	for _, arg := range mc.Args {
+		args = append(args, eval(r, arg))
+	}
+
+	result, mutates := mc.apply(r, receiver, args)
+	if mutates && assignable(place) {
+		assignTo(r, place, result)
+	}
+
+	return result
+}
+
+// apply calls the method on an evaluated receiver, mutates is true when the
+// result should be stored back into the receiver
+func (mc MethodCall) apply(r Runtime, receiver Data, args []Data) (Data, bool) {
+	var result Data
+	var mutates bool
+	switch receiver := receiver.(type) {
+	case ListData:
+		result, mutates = invoke(r, listMethods, receiver, mc.Name, args)
+	case MapData:
+		result, mutates = invoke(r, mapMethods, receiver, mc.Name, args)
+	case RecordData:
+		// record fields can hold functions
+		return call(r, mc.Name, FieldAccess{Receiver: mc.Receiver, Field: mc.Name}.field(receiver), args), false
+	case ModuleData:
+		return call(receiver.scope(r), mc.Name, receiver.export(mc.Name), args), false
+	default:
+		raise("%T has no method %s", receiver, mc.Name)
+	}
+
+	return result, mutates
+}
+
+// FieldAccess is value.name without parens, a record field or a property like list.len
+type FieldAccess struct {
+	Receiver Expression
+	Field    string
+}
+
+func (fa FieldAccess) String() string {
+	return fmt.Sprintf("%s.%s", fa.Receiver, fa.Field)
+}
+
+func (fa FieldAccess) Eval(r Runtime) Data {
+	return fa.apply(eval(r, fa.Receiver))
+}
+
+func (fa FieldAccess) apply(receiver Data) Data {
+	switch receiver := receiver.(type) {
+	case RecordData:
+		return fa.field(receiver)
+	case ModuleData:
+		return receiver.export(fa.Field)
+	case ListData:
+		if fa.Field == "len" {
+			return IntData{Value: len(receiver.Values), Literal: fmt.Sprintf("%d", len(receiver.Values))}
+		}
+	case MapData:
+		switch fa.Field {
+		case "len":
+			return IntData{Value: len(receiver.Keys), Literal: fmt.Sprintf("%d", len(receiver.Keys))}
+		case "keys":
+			var keys ListData
+			for _, key := range receiver.Keys {
+				keys.Values = append(keys.Values, StringData{Value: key, Literal: fmt.Sprintf("%q", key)})
+			}
+			return keys
+		}
+	}
+
+	raise("`%s` has no field %s", fa.Receiver, fa.Field)
+	return NoData{}
+}
+
+func (fa FieldAccess) field(record RecordData) Data {
+	value, ok := record.Values[fa.Field]
+	if !ok {
+		raise("record %s has no field %s", record.Type, fa.Field)
+	}
+
+	return value
+}
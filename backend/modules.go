@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adam-bunce/morpheus/util"
+)
+
+// ModuleLoader finds, evaluates and caches imported files, one loader is
+// shared by a runtime and every module it imports
+type ModuleLoader struct {
+	// Parse turns source into a program, backend can't depend on the parser
+	// so whoever runs the program sets it (see execute.RunWith)
+	Parse func(source string) Block
+	// SearchPath is where non relative imports are looked for after the
+	// importing file's directory
+	SearchPath []string
+
+	cache   map[string]ModuleData
+	loading []string // files currently being evaluated, outermost first
+}
+
+func NewModuleLoader() *ModuleLoader {
+	return &ModuleLoader{cache: map[string]ModuleData{}}
+}
+
+// resolve finds the file an import refers to, `./` and `../` imports are only
+// relative to dir, anything else also checks the search path
+func (ml *ModuleLoader) resolve(path, dir string) (string, bool) {
+	candidates := []string{filepath.Join(dir, path)}
+	if filepath.IsAbs(path) {
+		candidates = []string{path}
+	} else if !strings.HasPrefix(path, "./") && !strings.HasPrefix(path, "../") {
+		for _, searchDir := range ml.SearchPath {
+			candidates = append(candidates, filepath.Join(searchDir, path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			abs, err := filepath.Abs(candidate)
+			if err != nil {
+				return candidate, true
+			}
+			return abs, true
+		}
+	}
+
+	return "", false
+}
+
+// load evaluates the file at path once, later imports of it get the cached module
+func (ml *ModuleLoader) load(r Runtime, path string) ModuleData {
+	if module, ok := ml.cache[path]; ok {
+		return module
+	}
+
+	for i, loading := range ml.loading {
+		if loading == path {
+			cycle := append(append([]string{}, ml.loading[i:]...), path)
+			raise("import cycle %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	if ml.Parse == nil {
+		raise("can't import %s, the runtime has no parser", path)
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		raise("can't import %s: %s", path, err)
+	}
+
+	ml.loading = append(ml.loading, path)
+	defer func() { ml.loading = ml.loading[:len(ml.loading)-1] }()
+
+	// modules share natives and the solver but start with no variables
+	moduleRuntime := r
+	moduleRuntime.SymbolTable = map[string]Data{}
+	moduleRuntime.Dir = filepath.Dir(path)
+	moduleRuntime.File = path
+	ml.Parse(string(source)).Eval(moduleRuntime)
+
+	module := ModuleData{Path: path, Exports: moduleRuntime.SymbolTable}
+	ml.cache[path] = module
+
+	return module
+}
+
+// ModuleData is what `import "file.mph" as name` binds name to, every
+// variable the file defines is accessible as name.variable
+type ModuleData struct {
+	Path    string
+	Exports map[string]Data
+}
+
+func (md ModuleData) String() string {
+	var names []string
+	for name := range md.Exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Sprintf("ModuleData:%s{ %s }", md.Path, strings.Join(names, " "))
+}
+
+func (md ModuleData) export(name string) Data {
+	value, ok := md.Exports[name]
+	if !ok {
+		raise("module %s has no %s", md.Path, name)
+	}
+
+	return value
+}
+
+// scope is the runtime a module's functions run in, so they can see the
+// rest of the module instead of the caller's variables
+func (md ModuleData) scope(r Runtime) Runtime {
+	moduleRuntime := r
+	moduleRuntime.SymbolTable = util.DeepCopyMap(md.Exports)
+	moduleRuntime.Dir = filepath.Dir(md.Path)
+	moduleRuntime.File = md.Path
+
+	return moduleRuntime
+}
+
+type Import struct {
+	Path  string
+	Alias string
+}
+
+func NewImport(path string, alias string) Import {
+	return Import{Path: strings.Trim(path, "\"'"), Alias: alias}
+}
+
+func (i Import) String() string {
+	return fmt.Sprintf("import %q as %s", i.Path, i.Alias)
+}
+
+func (i Import) Eval(r Runtime) Data {
+	if r.Modules == nil {
+		raise("can't import %s, the runtime has no module loader", i.Path)
+	}
+
+	path, ok := r.Modules.resolve(i.Path, r.Dir)
+	if !ok {
+		raise("can't find module %s", i.Path)
+	}
+
+	r.SymbolTable[i.Alias] = r.Modules.load(r, path)
+	return NoData{}
+}
@@ -0,0 +1,130 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// OutputSink is where programs write files (like rendered layouts), names are
+// slash separated paths chosen by the program so sinks must not trust them
+type OutputSink interface {
+	WriteFile(name string, data []byte) error
+}
+
+// DefaultOutputDir is where NewRuntime's sink writes, relative to the
+// working directory
+const DefaultOutputDir = "output"
+
+// DirSink writes files inside Dir, names that are absolute or would leave Dir
+// (../other), including through symlinks, are rejected
+type DirSink struct {
+	Dir string
+}
+
+func (ds DirSink) WriteFile(name string, data []byte) error {
+	path := filepath.FromSlash(name)
+	if !filepath.IsLocal(path) {
+		return fmt.Errorf("can't write %q outside of the output directory", name)
+	}
+
+	if err := os.MkdirAll(ds.Dir, 0o755); err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(ds.Dir)
+	if err != nil {
+		return err
+	}
+
+	// directories that don't exist yet can't be symlinks, so once the ones
+	// that do are known to be inside root the rest can be made
+	path = filepath.Join(root, path)
+	if !inside(root, filepath.Dir(path)) {
+		return fmt.Errorf("can't write %q outside of the output directory", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("can't write %q through a symlink", name)
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// inside is true if the deepest part of dir that exists resolves to root or
+// somewhere under it
+func inside(root, dir string) bool {
+	existing := dir
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return false
+		}
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, resolved)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
+
+// MemorySink keeps written files in memory, for tests and servers that send
+// the output somewhere else
+type MemorySink struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{files: map[string][]byte{}}
+}
+
+func (ms *MemorySink) WriteFile(name string, data []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.files[name] = append([]byte{}, data...)
+	return nil
+}
+
+// File returns what was last written to name
+func (ms *MemorySink) File(name string) ([]byte, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	data, ok := ms.files[name]
+	return data, ok
+}
+
+// Names lists every file written, sorted
+func (ms *MemorySink) Names() []string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var names []string
+	for name := range ms.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// writeOutput sends a file to the runtime's sink, raising if it can't
+func writeOutput(r Runtime, name string, data []byte) {
+	if r.Output == nil {
+		raise("can't write %s, the runtime has no output", name)
+	}
+	if err := r.Output.WriteFile(name, data); err != nil {
+		raise("writing %s: %s", name, err)
+	}
+}
@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// WritePprof writes the profile in pprof's format (gzipped profile.proto) so
+// `go tool pprof` can show it. Samples are the statements run with each call
+// stack, valued by how many ran and their self time. Each source file gets
+// its own functions, fileName is the file for lines that didn't come from one
+func (p *Profiler) WritePprof(w io.Writer, fileName string) error {
+	var profile protobuf
+	indexes := map[string]int{"": 0}
+	table := []string{""}
+	str := func(s string) uint64 {
+		if i, ok := indexes[s]; ok {
+			return uint64(i)
+		}
+		indexes[s] = len(table)
+		table = append(table, s)
+		return uint64(len(table) - 1)
+	}
+
+	valueType := func(typ, unit string) []byte {
+		var vt protobuf
+		vt.uint64(1, str(typ))
+		vt.uint64(2, str(unit))
+		return vt.bytes
+	}
+	profile.message(1, valueType("statements", "count"))
+	profile.message(1, valueType("time", "nanoseconds"))
+
+	// samples are sorted so the same profile always encodes the same way
+	var keys []string
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// functions are keyed by name and file, modules can reuse names
+	type function struct{ name, file string }
+	functions := map[function]uint64{}
+	locations := map[location]uint64{}
+	var functionMessages, locationMessages [][]byte
+	for _, key := range keys {
+		s := p.samples[key]
+
+		var ids []uint64
+		for _, loc := range s.locations {
+			if loc.File == "" {
+				loc.File = fileName
+			}
+			// pprof drops names in <>, so code outside functions is named
+			// after the file instead
+			if loc.function == programName {
+				loc.function = filepath.Base(loc.File)
+			}
+
+			id, ok := locations[loc]
+			if !ok {
+				key := function{loc.function, loc.File}
+				fnId, ok := functions[key]
+				if !ok {
+					fnId = uint64(len(functions) + 1)
+					functions[key] = fnId
+
+					var fn protobuf
+					fn.uint64(1, fnId)
+					fn.uint64(2, str(loc.function))
+					fn.uint64(3, str(loc.function))
+					fn.uint64(4, str(loc.File))
+					functionMessages = append(functionMessages, fn.bytes)
+				}
+
+				id = uint64(len(locations) + 1)
+				locations[loc] = id
+
+				var line, location protobuf
+				line.uint64(1, fnId)
+				line.uint64(2, uint64(loc.Line))
+				location.uint64(1, id)
+				location.message(4, line.bytes)
+				locationMessages = append(locationMessages, location.bytes)
+			}
+			ids = append(ids, id)
+		}
+
+		var sample protobuf
+		sample.packed(1, ids)
+		sample.packed(2, []uint64{uint64(s.count), uint64(s.self.Nanoseconds())})
+		profile.message(2, sample.bytes)
+	}
+
+	for _, location := range locationMessages {
+		profile.message(4, location)
+	}
+	for _, fn := range functionMessages {
+		profile.message(5, fn)
+	}
+
+	if !p.start.IsZero() {
+		profile.uint64(9, uint64(p.start.UnixNano()))
+	}
+	profile.uint64(10, uint64(p.Total().Nanoseconds()))
+	profile.message(11, valueType("time", "nanoseconds"))
+	profile.uint64(14, str("time"))
+
+	// the string table has to come last since encoding the rest fills it
+	for _, s := range table {
+		profile.message(6, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile.bytes); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// protobuf encodes the few kinds of protocol buffer fields pprof needs
+type protobuf struct {
+	bytes []byte
+}
+
+func (pb *protobuf) varint(v uint64) {
+	for v >= 0x80 {
+		pb.bytes = append(pb.bytes, byte(v)|0x80)
+		v >>= 7
+	}
+	pb.bytes = append(pb.bytes, byte(v))
+}
+
+func (pb *protobuf) uint64(field int, v uint64) {
+	pb.varint(uint64(field) << 3) // wire type 0, varint
+	pb.varint(v)
+}
+
+// message is a length delimited field, an embedded message or a string
+func (pb *protobuf) message(field int, b []byte) {
+	pb.varint(uint64(field)<<3 | 2)
+	pb.varint(uint64(len(b)))
+	pb.bytes = append(pb.bytes, b...)
+}
+
+func (pb *protobuf) packed(field int, values []uint64) {
+	var packed protobuf
+	for _, v := range values {
+		packed.varint(v)
+	}
+	pb.message(field, packed.bytes)
+}
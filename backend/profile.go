@@ -0,0 +1,306 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// programName is what profiles call the code outside any function
+const programName = "<program>"
+
+// Profiler is a CallHook and SolverHook that records how long each function and source line
+// takes and how often they run, and how long the layout solver takes. Like
+// every hook it expects one program at a time
+type Profiler struct {
+	Functions map[string]*FunctionProfile
+	Lines     map[SourceLine]*LineProfile
+	// SolverTime is spent in the solver adding boxes, groups and the
+	// constraints between them, SolverCalls is how many were added
+	SolverTime  time.Duration
+	SolverCalls int
+
+	start, end time.Time
+	statements []activeStatement // innermost last
+	calls      []activeCall
+	samples    map[string]*sample
+}
+
+// FunctionProfile is where a function spent its time, Time includes the
+// functions it called and Self doesn't. Time spent in a recursive call is
+// only counted once
+type FunctionProfile struct {
+	Name       string
+	Calls      int
+	Time, Self time.Duration
+
+	running int
+}
+
+// SourceLine is a line of a source file, File is empty for source that
+// didn't come from one
+type SourceLine struct {
+	File string
+	Line int
+}
+
+func (l SourceLine) String() string {
+	if l.File == "" {
+		return fmt.Sprint(l.Line)
+	}
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// LineProfile is where a source line spent its time, Count is how many times
+// statements on it ran
+type LineProfile struct {
+	SourceLine
+	Count      int
+	Time, Self time.Duration
+
+	running int
+}
+
+type activeStatement struct {
+	pos    Position
+	depth  int
+	start  time.Time
+	nested time.Duration // spent in statements run inside this one
+	sample *sample
+}
+
+type activeCall struct {
+	fn     *FunctionProfile
+	start  time.Time
+	nested time.Duration // spent in calls made by this one
+}
+
+// sample is the self time of the statements run with one call stack
+type sample struct {
+	locations []location // innermost first
+	count     int
+	self      time.Duration
+}
+
+// location is a line in a function, the statement running or a call site
+type location struct {
+	function string
+	SourceLine
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		Functions: map[string]*FunctionProfile{},
+		Lines:     map[SourceLine]*LineProfile{},
+		samples:   map[string]*sample{},
+	}
+}
+
+func (p *Profiler) function(name string) *FunctionProfile {
+	fn, ok := p.Functions[name]
+	if !ok {
+		fn = &FunctionProfile{Name: name}
+		p.Functions[name] = fn
+	}
+	return fn
+}
+
+func (p *Profiler) line(pos Position) *LineProfile {
+	line := SourceLine{File: pos.File, Line: pos.Line}
+	lp, ok := p.Lines[line]
+	if !ok {
+		lp = &LineProfile{SourceLine: line}
+		p.Lines[line] = lp
+	}
+	return lp
+}
+
+func (p *Profiler) Before(r Runtime, e Expression, pos Position) {
+	now := time.Now()
+	if p.start.IsZero() {
+		p.start = now
+	}
+	if pos.Line == 0 {
+		return
+	}
+
+	// runtimes not made by NewRuntime or WithLimits have no call stack
+	var frames []StackFrame
+	if r.calls != nil {
+		frames = r.calls.frames
+	}
+	lp := p.line(pos)
+	lp.Count++
+	lp.running++
+
+	p.statements = append(p.statements, activeStatement{
+		pos:    pos,
+		depth:  len(frames),
+		start:  now,
+		sample: p.sample(frames, pos),
+	})
+}
+
+func (p *Profiler) After(r Runtime, e Expression, pos Position, result Data) {
+	now := time.Now()
+	p.end = now
+	if pos.Line == 0 {
+		return
+	}
+
+	// statements that raised never finish, they're dropped when something
+	// that caught the error carries on
+	depth := r.calls.depth()
+	for len(p.statements) > 0 {
+		top := p.statements[len(p.statements)-1]
+		p.statements = p.statements[:len(p.statements)-1]
+		if top.pos == pos && top.depth == depth {
+			p.finish(top, now)
+			return
+		}
+		p.line(top.pos).running--
+	}
+}
+
+func (p *Profiler) finish(statement activeStatement, now time.Time) {
+	elapsed := now.Sub(statement.start)
+	self := elapsed - statement.nested
+
+	lp := p.line(statement.pos)
+	lp.Self += self
+	lp.running--
+	if lp.running == 0 {
+		lp.Time += elapsed
+	}
+
+	statement.sample.count++
+	statement.sample.self += self
+
+	if len(p.statements) > 0 {
+		p.statements[len(p.statements)-1].nested += elapsed
+	}
+}
+
+func (p *Profiler) Enter(r Runtime, frame StackFrame) {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+
+	fn := p.function(frame.Function)
+	fn.Calls++
+	fn.running++
+	p.calls = append(p.calls, activeCall{fn: fn, start: time.Now()})
+}
+
+func (p *Profiler) Leave(r Runtime) {
+	if len(p.calls) == 0 {
+		return
+	}
+
+	call := p.calls[len(p.calls)-1]
+	p.calls = p.calls[:len(p.calls)-1]
+
+	p.end = time.Now()
+	elapsed := p.end.Sub(call.start)
+	call.fn.Self += elapsed - call.nested
+	call.fn.running--
+	if call.fn.running == 0 {
+		call.fn.Time += elapsed
+	}
+
+	if len(p.calls) > 0 {
+		p.calls[len(p.calls)-1].nested += elapsed
+	}
+}
+
+// sample is the sample for statements at pos with the call stack frames
+func (p *Profiler) sample(frames []StackFrame, pos Position) *sample {
+	var key strings.Builder
+	locations := make([]location, 0, len(frames)+1)
+	for i := len(frames); i >= 0; i-- {
+		loc := location{function: programName, SourceLine: SourceLine{File: pos.File, Line: pos.Line}}
+		if i > 0 {
+			loc.function = frames[i-1].Function
+		}
+		if i < len(frames) {
+			loc.SourceLine = SourceLine{File: frames[i].File, Line: frames[i].Line}
+		}
+		locations = append(locations, loc)
+		fmt.Fprintf(&key, "%s %s:%d;", loc.function, loc.File, loc.Line)
+	}
+
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &sample{locations: locations}
+		p.samples[key.String()] = s
+	}
+	return s
+}
+
+// solve runs fn, which adds things to the solver for the source line, so
+// they're traced as coming from there and timed for a SolverHook
+func solve(r Runtime, line int, fn func()) {
+	hook, ok := r.Hook.(SolverHook)
+	if !ok {
+		r.Constraints.at(line, fn)
+		return
+	}
+
+	start := time.Now()
+	r.Constraints.at(line, fn)
+	hook.Solved(r, line, time.Since(start))
+}
+
+func (p *Profiler) Solved(r Runtime, line int, elapsed time.Duration) {
+	p.SolverTime += elapsed
+	p.SolverCalls++
+}
+
+// Total is the time from the first expression or call to the last
+func (p *Profiler) Total() time.Duration {
+	return p.end.Sub(p.start)
+}
+
+// Report writes a flat text report, functions by total time then lines by
+// self time
+func (p *Profiler) Report(w io.Writer) {
+	fmt.Fprintf(w, "total %s, solver %s adding %d boxes, groups and constraints\n\n",
+		p.Total().Round(time.Microsecond), p.SolverTime.Round(time.Microsecond), p.SolverCalls)
+
+	var functions []*FunctionProfile
+	for _, fn := range p.Functions {
+		functions = append(functions, fn)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Time != functions[j].Time {
+			return functions[i].Time > functions[j].Time
+		}
+		return functions[i].Name < functions[j].Name
+	})
+
+	fmt.Fprintf(w, "%10s %12s %12s  %s\n", "calls", "total", "self", "function")
+	for _, fn := range functions {
+		fmt.Fprintf(w, "%10d %12s %12s  %s\n", fn.Calls, fn.Time.Round(time.Microsecond), fn.Self.Round(time.Microsecond), fn.Name)
+	}
+
+	var lines []*LineProfile
+	for _, lp := range p.Lines {
+		lines = append(lines, lp)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Self != lines[j].Self {
+			return lines[i].Self > lines[j].Self
+		}
+		if lines[i].File != lines[j].File {
+			return lines[i].File < lines[j].File
+		}
+		return lines[i].Line < lines[j].Line
+	})
+
+	fmt.Fprintf(w, "\n%10s %12s %12s  %s\n", "count", "total", "self", "line")
+	for _, lp := range lines {
+		fmt.Fprintf(w, "%10d %12s %12s  %s\n", lp.Count, lp.Time.Round(time.Microsecond), lp.Self.Round(time.Microsecond), lp.SourceLine)
+	}
+}
@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+type RecordDecl struct {
+	Name       string
+	Fields     []string
+	FieldTypes []string // optional annotations, "" where a field has none
+}
+
+func (rd RecordDecl) String() string {
+	return fmt.Sprintf("record %s { %s }", rd.Name, strings.Join(rd.Fields, ", "))
+}
+
+func (rd RecordDecl) Eval(r Runtime) Data {
+	r.SymbolTable[rd.Name] = RecordTypeData{
+		Name:   rd.Name,
+		Fields: rd.Fields,
+	}
+
+	return NoData{}
+}
+
+// construct builds a record from positional arguments, Card("title", 100)
+func (rt RecordTypeData) construct(args []Data) RecordData {
+	if len(args) != len(rt.Fields) {
+		raise("record %s expects %d fields got %d", rt.Name, len(rt.Fields), len(args))
+	}
+
+	record := RecordData{
+		Type:   rt.Name,
+		Fields: rt.Fields,
+		Values: map[string]Data{},
+	}
+	for i, field := range rt.Fields {
+		record.Values[field] = args[i]
+	}
+
+	return record
+}
+
+// FieldAssign is record.field = expr, it builds a new record (see assignTo)
+type FieldAssign struct {
+	Receiver Expression
+	Field    string
+	Value    Expression
+}
+
+func (fa FieldAssign) String() string {
+	return fmt.Sprintf("%s.%s = %s", fa.Receiver, fa.Field, fa.Value)
+}
+
+func (fa FieldAssign) Eval(r Runtime) Data {
+	value := eval(r, fa.Value)
+
+	place := resolve(r, fa.Receiver)
+	record, ok := eval(r, place).(RecordData)
+	if !ok {
+		raise("attempt to assign field %s of non-RecordData `%s`", fa.Field, fa.Receiver)
+	}
+	if _, ok := record.Values[fa.Field]; !ok {
+		raise("record %s has no field %s", record.Type, fa.Field)
+	}
+
+	newRecord := RecordData{
+		Type:   record.Type,
+		Fields: record.Fields,
+		Values: map[string]Data{},
+	}
+	for field, fieldValue := range record.Values {
+		newRecord.Values[field] = fieldValue
+	}
+	newRecord.Values[fa.Field] = value
+
+	assignTo(r, place, newRecord)
+
+	return NoData{}
+}
@@ -4,19 +4,79 @@ import (
 	"fmt"
 	"github.com/adam-bunce/morpheus/util"
 	"github.com/lithdew/casso"
+	"io"
+	"os"
 	"strings"
 )
 
 type Runtime struct {
 	SymbolTable map[string]Data
 	Solver      *casso.Solver
+	// Constraints records what the layout adds to Solver, nil to not keep a record
+	Constraints *ConstraintTrace
+	// Natives are go functions scripts can call, shared by every scope of the runtime
+	Natives map[string]NativeFunctionData
+	Modules *ModuleLoader
+	// Dir is the directory of the file being run, imports are relative to it
+	Dir string
+	// File is the file being run, the positions hooks see and stack frames
+	// are in it. Empty if the source didn't come from a file
+	File string
+	// Output is where rendered files go, by default DefaultOutputDir
+	Output OutputSink
+	// Stdout is where print writes
+	Stdout io.Writer
+	// MaxCallDepth is how deep function calls can nest, 0 for no limit. Calls
+	// in tail position don't count
+	MaxCallDepth int
+	// Hook is called around every expression the interpreter evaluates, nil
+	// for none. The bytecode vm only calls a CallHook's Enter and Leave
+	Hook Hook
+
+	budget *budget      // nil unless WithLimits was used
+	tests  *[]TestBlock // nil unless CollectTests was used
+	calls  *callStack
 }
 
+// NewRuntime returns an empty runtime with the standard builtins registered
 func NewRuntime() Runtime {
+	natives := map[string]NativeFunctionData{}
+	for name, native := range builtins {
+		natives[name] = native
+	}
+	for name, native := range assertions {
+		natives[name] = native
+	}
+
+	solver := casso.NewSolver()
 	return Runtime{
 		SymbolTable: map[string]Data{},
-		Solver:      casso.NewSolver(),
+		Solver:      solver,
+		Constraints: NewConstraintTrace(solver),
+		Natives:     natives,
+		Modules:     NewModuleLoader(),
+		Output:      DirSink{Dir: DefaultOutputDir},
+		Stdout:      os.Stdout,
+
+		MaxCallDepth: DefaultMaxCallDepth,
+		calls:        &callStack{},
+	}
+}
+
+// Register makes fn callable from scripts as name, arity is the number of
+// arguments it takes or -1 for any number. Errors fn returns are raised as
+// runtime errors in the script, registering an existing name replaces it
+func (r Runtime) Register(name string, arity int, fn func(args []Data) (Data, error)) {
+	if r.Natives == nil {
+		panic("Register on a runtime without natives, use NewRuntime")
 	}
+
+	r.Natives[name] = NativeFunctionData{Name: name, Arity: arity, Fn: fn}
+}
+
+// CallStack is the functions running right now, innermost first
+func (r Runtime) CallStack() []StackFrame {
+	return r.calls.trace()
 }
 
 func (r Runtime) String() string {
@@ -35,9 +95,8 @@ func (r Runtime) String() string {
 
 // SubScope returns a copy of the parents runtime with new bindings
 func (r Runtime) SubScope(bindings map[string]Data) Runtime {
-	newRuntime := Runtime{
-		SymbolTable: util.DeepCopyMap(r.SymbolTable),
-	}
+	newRuntime := r
+	newRuntime.SymbolTable = util.DeepCopyMap(r.SymbolTable)
 
 	for name, data := range bindings {
 		newRuntime.SymbolTable[name] = data
@@ -45,3 +104,13 @@ func (r Runtime) SubScope(bindings map[string]Data) Runtime {
 
 	return newRuntime
 }
+
+// lookup finds a variable, falling back to the native functions
+func (r Runtime) lookup(name string) (Data, bool) {
+	if data, ok := r.SymbolTable[name]; ok {
+		return data, true
+	}
+
+	native, ok := r.Natives[name]
+	return native, ok
+}
@@ -0,0 +1,241 @@
+package backend
+
+import (
+	"fmt"
+	"github.com/lithdew/casso"
+	"strings"
+)
+
+// Stack lines its items up one after another, left to right for an HStack
+// and top to bottom for a VStack, Spacing apart. Alignment is where items sit
+// across the stack: top, center or bottom in an HStack and left, center or
+// right in a VStack
+type Stack struct {
+	Items      []LayoutItem
+	Horizontal bool
+	Spacing    float64
+	Alignment  string
+	solver     *casso.Solver
+	trace      *ConstraintTrace
+
+	X casso.Symbol
+	Y casso.Symbol
+	H casso.Symbol
+	W casso.Symbol
+}
+
+// stackAlignments are the alignments each kind of stack takes, the first is
+// the default
+var stackAlignments = map[bool][]string{
+	true:  {"top", "center", "bottom"},
+	false: {"left", "center", "right"},
+}
+
+func NewStack(solver *casso.Solver, horizontal bool, spacing float64, alignment string, items ...LayoutItem) Stack {
+	return newStack(solver, nil, horizontal, spacing, alignment, items...)
+}
+
+// newStack is NewStack recording its constraints in trace
+func newStack(solver *casso.Solver, trace *ConstraintTrace, horizontal bool, spacing float64, alignment string, items ...LayoutItem) Stack {
+	alignments := stackAlignments[horizontal]
+	if alignment == "" {
+		alignment = alignments[0]
+	}
+	if !contains(alignments, alignment) {
+		raise("%s alignment must be %s got %q", stackKind(horizontal), strings.Join(alignments, ", "), alignment)
+	}
+
+	stack := Stack{
+		Items:      items,
+		Horizontal: horizontal,
+		Spacing:    spacing,
+		Alignment:  alignment,
+		solver:     solver,
+		trace:      trace,
+		X:          casso.New(),
+		Y:          casso.New(),
+		H:          casso.New(),
+		W:          casso.New(),
+	}
+	trace.item(trace.container(strings.ToLower(stackKind(horizontal))), stack.X, stack.Y, stack.W, stack.H)
+
+	add := func(relation string, items []LayoutItem, op casso.Op, constant float64, terms ...Term) {
+		if err := constrain(solver, trace, relation, items, op, constant, terms...); err != nil {
+			raise("failed to add %s %s constraint. err=%v", stackKind(horizontal), relation, err)
+		}
+	}
+
+	// main is where an item starts along the stack and how long it is, cross
+	// is the same the other way
+	main := func(item LayoutItem) (casso.Symbol, casso.Symbol) {
+		if horizontal {
+			return item.GetX(), item.GetW()
+		}
+		return item.GetY(), item.GetH()
+	}
+	cross := func(item LayoutItem) (casso.Symbol, casso.Symbol) {
+		if horizontal {
+			return item.GetY(), item.GetH()
+		}
+		return item.GetX(), item.GetW()
+	}
+	stackStart, stackLength := main(stack)
+	stackCrossStart, stackCrossLength := cross(stack)
+
+	// like groups the stack is as thick as its thickest item when it's made
+	var thickest float64
+	for _, item := range items {
+		thickness := item.Bottom() - item.Top()
+		if !horizontal {
+			thickness = item.RightEdge() - item.LeftEdge()
+		}
+		if thickness > thickest {
+			thickest = thickness
+		}
+	}
+
+	across := "is as tall as its items"
+	if !horizontal {
+		across = "is as wide as its items"
+	}
+	add(across, []LayoutItem{stack}, casso.EQ, -thickest, termOf(stackCrossLength, 1))
+
+	// keep the stack in bounds of the screen
+	add("is on screen", []LayoutItem{stack}, casso.GTE, 0, termOf(stack.X, 1))
+	add("is on screen", []LayoutItem{stack}, casso.GTE, 0, termOf(stack.Y, 1))
+
+	if len(items) == 0 {
+		add("is empty", []LayoutItem{stack}, casso.EQ, 0, termOf(stackLength, 1))
+		return stack
+	}
+
+	for i, item := range items {
+		start, _ := main(item)
+		if i == 0 {
+			// first.start = stack.start
+			add("starts with", []LayoutItem{stack, item}, casso.EQ, 0, termOf(start, 1), termOf(stackStart, -1))
+		} else {
+			// item.start = previous.start + previous.length + spacing
+			previousStart, previousLength := main(items[i-1])
+			add("follows", []LayoutItem{item, items[i-1]}, casso.EQ, -spacing,
+				termOf(start, 1), termOf(previousStart, -1), termOf(previousLength, -1))
+		}
+
+		crossStart, crossLength := cross(item)
+		switch alignment {
+		case "top", "left":
+			// item.cross = stack.cross
+			add("is aligned to the "+alignment+" of", []LayoutItem{item, stack}, casso.EQ, 0,
+				termOf(crossStart, 1), termOf(stackCrossStart, -1))
+		case "center":
+			// item.cross + item.thickness / 2 = stack.cross + stack.thickness / 2
+			add("is centered in", []LayoutItem{item, stack}, casso.EQ, 0,
+				termOf(crossStart, 1), termOf(crossLength, 0.5), termOf(stackCrossStart, -1), termOf(stackCrossLength, -0.5))
+		case "bottom", "right":
+			// item.cross + item.thickness = stack.cross + stack.thickness
+			add("is aligned to the "+alignment+" of", []LayoutItem{item, stack}, casso.EQ, 0,
+				termOf(crossStart, 1), termOf(crossLength, 1), termOf(stackCrossStart, -1), termOf(stackCrossLength, -1))
+		}
+	}
+
+	// stack.length = last.start + last.length - stack.start
+	last := items[len(items)-1]
+	lastStart, lastLength := main(last)
+	add("ends with", []LayoutItem{stack, last}, casso.EQ, 0,
+		termOf(stackLength, 1), termOf(lastStart, -1), termOf(lastLength, -1), termOf(stackStart, 1))
+
+	return stack
+}
+
+func stackKind(horizontal bool) string {
+	if horizontal {
+		return "HStack"
+	}
+	return "VStack"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Stack) RightEdge() float64 { return s.solver.Val(s.X) + s.solver.Val(s.W) }
+func (s Stack) LeftEdge() float64  { return s.solver.Val(s.X) }
+func (s Stack) Top() float64       { return s.solver.Val(s.Y) }
+func (s Stack) Bottom() float64    { return s.solver.Val(s.Y) + s.solver.Val(s.H) }
+func (s Stack) GetX() casso.Symbol { return s.X }
+func (s Stack) GetY() casso.Symbol { return s.Y }
+func (s Stack) GetW() casso.Symbol { return s.W }
+func (s Stack) GetH() casso.Symbol { return s.H }
+
+func (s Stack) AsHtml() string {
+	var sb strings.Builder
+
+	sb.WriteString("<div>")
+
+	for _, item := range s.Items {
+		sb.WriteString(item.AsHtml())
+	}
+
+	sb.WriteString("</div>")
+
+	return sb.String()
+}
+
+func (s Stack) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("%s{X:%.2f Y:%.2f W:%.2f H:%.2f",
+		strings.ToUpper(stackKind(s.Horizontal)),
+		s.solver.Val(s.X),
+		s.solver.Val(s.Y),
+		s.solver.Val(s.W),
+		s.solver.Val(s.H),
+	))
+
+	sb.WriteString("\nCHILDREN:{\n")
+
+	for _, child := range s.Items {
+		sb.WriteString("\t" + child.String())
+		sb.WriteString("\n")
+	}
+	sb.WriteString("}}")
+
+	return sb.String()
+}
+
+func (s Stack) IsLeftOf(item LayoutItem) {
+	// s.X + s.W <= item.x
+	err := constrain(s.solver, s.trace, "is left of", []LayoutItem{s, item}, casso.LTE, 0, termOf(s.X, 1), termOf(s.W, 1), termOf(item.GetX(), -1))
+	if err != nil {
+		raise("failed to add %s IsLeftOf constraint. err=%v", stackKind(s.Horizontal), err)
+	}
+}
+
+func (s Stack) IsRightOf(item LayoutItem) {
+	// s.X >= item.x + item.W
+	err := constrain(s.solver, s.trace, "is right of", []LayoutItem{s, item}, casso.GTE, 0, termOf(s.X, 1), termOf(item.GetX(), -1), termOf(item.GetW(), -1))
+	if err != nil {
+		raise("failed to add %s IsRightOf constraint. err=%v", stackKind(s.Horizontal), err)
+	}
+}
+
+func (s Stack) IsBelow(item LayoutItem) {
+	// s.Y >= item.Y + item.H
+	err := constrain(s.solver, s.trace, "is below", []LayoutItem{s, item}, casso.GTE, 0, termOf(s.Y, 1), termOf(item.GetY(), -1), termOf(item.GetH(), -1))
+	if err != nil {
+		raise("failed to add %s IsBelow constraint. err=%v", stackKind(s.Horizontal), err)
+	}
+}
+
+func (s Stack) IsAbove(item LayoutItem) {
+	// s.Y + s.H <= item.Y
+	err := constrain(s.solver, s.trace, "is above", []LayoutItem{s, item}, casso.LTE, 0, termOf(s.Y, 1), termOf(s.H, 1), termOf(item.GetY(), -1))
+	if err != nil {
+		raise("failed to add %s IsAbove constraint. err=%v", stackKind(s.Horizontal), err)
+	}
+}
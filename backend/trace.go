@@ -0,0 +1,283 @@
+package backend
+
+import (
+	"fmt"
+	"github.com/lithdew/casso"
+	"math"
+	"strings"
+)
+
+// ConstraintTrace records every constraint the layout adds to the solver so
+// Explain can say why an item ended up where it did. It's shared by every
+// scope of a runtime
+type ConstraintTrace struct {
+	Constraints []TracedConstraint
+
+	solver     *casso.Solver
+	names      map[casso.Symbol]string // solver variables, like a.x
+	ids        map[string]int          // how many items have had each name
+	containers map[string]int          // how many of each kind of container there are
+	line       int                     // where constraints being added come from
+	uses       map[casso.Symbol][]int  // the constraints each variable is in
+}
+
+// Term is a solver variable times a coefficient
+type Term struct {
+	Name   string
+	Symbol casso.Symbol
+	Coeff  float64
+}
+
+// TracedConstraint is a constraint the layout added, it holds when the sum
+// of Terms and Constant compared to 0 by Op is true
+type TracedConstraint struct {
+	Line     int      // the source line that added it, 0 if it wasn't from source
+	Items    []string // the layout items it's about
+	Relation string   // why it was added, like "is left of" or "width"
+	Priority casso.Priority
+	Op       casso.Op
+	Constant float64
+	Terms    []Term
+}
+
+func NewConstraintTrace(solver *casso.Solver) *ConstraintTrace {
+	return &ConstraintTrace{
+		solver:     solver,
+		names:      map[casso.Symbol]string{},
+		ids:        map[string]int{},
+		containers: map[string]int{},
+		uses:       map[casso.Symbol][]int{},
+	}
+}
+
+// Strength is the priority's name
+func (tc TracedConstraint) Strength() string {
+	switch {
+	case tc.Priority >= casso.Required:
+		return "required"
+	case tc.Priority >= casso.Strong:
+		return "strong"
+	case tc.Priority >= casso.Medium:
+		return "medium"
+	default:
+		return "weak"
+	}
+}
+
+// Expr is the linear expression with the constant on the right, like
+// a.x + a.w - b.x <= 0
+func (tc TracedConstraint) Expr() string {
+	var sb strings.Builder
+	for i, term := range tc.Terms {
+		coeff := term.Coeff
+		switch {
+		case i == 0 && coeff < 0:
+			sb.WriteString("-")
+			coeff = -coeff
+		case i > 0 && coeff < 0:
+			sb.WriteString(" - ")
+			coeff = -coeff
+		case i > 0:
+			sb.WriteString(" + ")
+		}
+		if coeff != 1 {
+			sb.WriteString(fmt.Sprintf("%g*", coeff))
+		}
+		sb.WriteString(term.Name)
+	}
+
+	sb.WriteString(fmt.Sprintf(" %s %g", tc.Op, 0-tc.Constant))
+	return sb.String()
+}
+
+func (tc TracedConstraint) String() string {
+	var where string
+	if tc.Line > 0 {
+		where = fmt.Sprintf("line %d: ", tc.Line)
+	}
+	return fmt.Sprintf("%s%s %s: %s (%s)", where, strings.Join(tc.Items, ", "), tc.Relation, tc.Expr(), tc.Strength())
+}
+
+// active is true if the constraint is holding the solution where it is, it's
+// exactly met. required equalities always are, inequalities and weaker
+// equalities the solver gave up on aren't
+func (tc TracedConstraint) active(solver *casso.Solver) bool {
+	value := tc.Constant
+	for _, term := range tc.Terms {
+		value += term.Coeff * solver.Val(term.Symbol)
+	}
+	return math.Abs(value) < 1e-6
+}
+
+// at makes the constraints added by fn come from line
+func (ct *ConstraintTrace) at(line int, fn func()) {
+	if ct == nil {
+		fn()
+		return
+	}
+
+	previous := ct.line
+	ct.line = line
+	defer func() { ct.line = previous }()
+
+	fn()
+}
+
+// item names a new layout item's variables, items with the same name are
+// told apart by a #n suffix. It returns the name it used
+func (ct *ConstraintTrace) item(name string, x, y, w, h casso.Symbol) string {
+	if ct == nil {
+		return ""
+	}
+
+	ct.ids[name]++
+	if ct.ids[name] > 1 {
+		name = fmt.Sprintf("%s#%d", name, ct.ids[name])
+	}
+
+	ct.names[x], ct.names[y], ct.names[w], ct.names[h] = name+".x", name+".y", name+".w", name+".h"
+	return name
+}
+
+// variable names a solver variable that isn't an item's, like a grid column's
+func (ct *ConstraintTrace) variable(name string, symbol casso.Symbol) {
+	if ct == nil {
+		return
+	}
+
+	ct.names[symbol] = name
+}
+
+// container is the name for a new group or stack, like group1 or hstack2
+func (ct *ConstraintTrace) container(kind string) string {
+	if ct == nil {
+		return ""
+	}
+
+	ct.containers[kind]++
+	return fmt.Sprintf("%s%d", kind, ct.containers[kind])
+}
+
+// name is what the trace calls an item, ? if it wasn't made through it
+func (ct *ConstraintTrace) name(item LayoutItem) string {
+	name, ok := ct.names[item.GetX()]
+	if !ok {
+		return "?"
+	}
+	return strings.TrimSuffix(name, ".x")
+}
+
+func (ct *ConstraintTrace) record(relation string, items []LayoutItem, priority casso.Priority, op casso.Op, constant float64, terms []Term) {
+	traced := TracedConstraint{Line: ct.line, Relation: relation, Priority: priority, Op: op, Constant: constant}
+	for _, item := range items {
+		traced.Items = append(traced.Items, ct.name(item))
+	}
+	for _, term := range terms {
+		name, ok := ct.names[term.Symbol]
+		if !ok {
+			name = "?"
+		}
+		traced.Terms = append(traced.Terms, Term{Name: name, Symbol: term.Symbol, Coeff: term.Coeff})
+		ct.uses[term.Symbol] = append(ct.uses[term.Symbol], len(ct.Constraints))
+	}
+
+	ct.Constraints = append(ct.Constraints, traced)
+}
+
+// termOf is a term for constrain
+func termOf(symbol casso.Symbol, coeff float64) Term {
+	return Term{Symbol: symbol, Coeff: coeff}
+}
+
+// constrain adds a required constraint that the sum of terms and constant
+// compares to 0 by op, recording it in the trace if there is one
+func constrain(solver *casso.Solver, trace *ConstraintTrace, relation string, items []LayoutItem, op casso.Op, constant float64, terms ...Term) error {
+	return constrainWith(solver, trace, casso.Required, relation, items, op, constant, terms...)
+}
+
+// constrainWith is constrain for constraints the solver can break when
+// stronger ones need it to
+func constrainWith(solver *casso.Solver, trace *ConstraintTrace, priority casso.Priority, relation string, items []LayoutItem, op casso.Op, constant float64, terms ...Term) error {
+	var symbols []casso.Term
+	for _, term := range terms {
+		symbols = append(symbols, term.Symbol.T(term.Coeff))
+	}
+
+	if _, err := solver.AddConstraintWithPriority(priority, casso.NewConstraint(op, constant, symbols...)); err != nil {
+		return err
+	}
+	if trace != nil {
+		trace.record(relation, items, priority, op, constant, terms)
+	}
+	return nil
+}
+
+// Explain is the active constraints that put item where it is, the ones on
+// its own variables and then the ones on the variables those involve, in the
+// order they were added
+func (ct *ConstraintTrace) Explain(item LayoutItem) []TracedConstraint {
+	seen := map[casso.Symbol]bool{}
+	chosen := map[int]bool{}
+	queue := []casso.Symbol{item.GetX(), item.GetY(), item.GetW(), item.GetH()}
+	for _, symbol := range queue {
+		seen[symbol] = true
+	}
+
+	for len(queue) > 0 {
+		symbol := queue[0]
+		queue = queue[1:]
+
+		for _, i := range ct.uses[symbol] {
+			if chosen[i] || !ct.Constraints[i].active(ct.solver) {
+				continue
+			}
+			chosen[i] = true
+
+			for _, term := range ct.Constraints[i].Terms {
+				if !seen[term.Symbol] {
+					seen[term.Symbol] = true
+					queue = append(queue, term.Symbol)
+				}
+			}
+		}
+	}
+
+	var explanation []TracedConstraint
+	for i, c := range ct.Constraints {
+		if chosen[i] {
+			explanation = append(explanation, c)
+		}
+	}
+	return explanation
+}
+
+// traceOf is the trace an item's constraints were recorded in, nil if they weren't
+func traceOf(item LayoutItem) *ConstraintTrace {
+	switch item := item.(type) {
+	case Box:
+		return item.trace
+	case Group:
+		return item.trace
+	case Stack:
+		return item.trace
+	case Grid:
+		return item.trace
+	case Span:
+		return traceOf(item.LayoutItem)
+	}
+	return nil
+}
+
+// ExplainString is Explain as text, the item's position then a constraint
+// per line
+func (ct *ConstraintTrace) ExplainString(item LayoutItem) string {
+	var sb strings.Builder
+	// adding 0 turns the solver's -0s into 0s
+	sb.WriteString(fmt.Sprintf("%s x=%g y=%g w=%g h=%g\n", ct.name(item), item.LeftEdge()+0, item.Top()+0,
+		item.RightEdge()-item.LeftEdge()+0, item.Bottom()-item.Top()+0))
+	for _, c := range ct.Explain(item) {
+		sb.WriteString("  " + c.String() + "\n")
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,729 @@
+package backend
+
+import (
+	"fmt"
+	"github.com/adam-bunce/morpheus/util"
+	"strings"
+)
+
+type TypeKind int
+
+const (
+	UnknownType TypeKind = iota // could be anything, never reported as a mismatch
+	NoneType
+	IntType
+	BoolType
+	StringType
+	ListType
+	FunctionType
+	LayoutType
+	MapType
+	RecordType
+)
+
+var TypeKindToStr = map[TypeKind]string{
+	UnknownType:  "?",
+	NoneType:     "none",
+	IntType:      "int",
+	BoolType:     "bool",
+	StringType:   "string",
+	ListType:     "list",
+	FunctionType: "function",
+	LayoutType:   "layout",
+	MapType:      "map",
+	RecordType:   "record",
+}
+
+// Type is what the checker infers for an expression, Elem is only set for
+// lists and maps (the value type), Params/Return/Variadic only for functions
+// and Name only for records
+type Type struct {
+	Kind     TypeKind
+	Elem     *Type
+	Params   []Type
+	Variadic bool // any number of arguments can follow Params
+	Return   *Type
+	Name     string
+}
+
+func (t Type) String() string {
+	switch t.Kind {
+	case ListType:
+		if t.Elem == nil {
+			return "list"
+		}
+		return fmt.Sprintf("list[%s]", t.Elem)
+	case MapType:
+		if t.Elem == nil {
+			return "map"
+		}
+		return fmt.Sprintf("map[%s]", t.Elem)
+	case FunctionType:
+		var params []string
+		for _, param := range t.Params {
+			params = append(params, param.String())
+		}
+
+		ret := Type{Kind: UnknownType}
+		if t.Return != nil {
+			ret = *t.Return
+		}
+		return fmt.Sprintf("function(%s) -> %s", strings.Join(params, ", "), ret)
+	case RecordType:
+		if t.Name == "" {
+			return "record"
+		}
+		return t.Name
+	default:
+		return TypeKindToStr[t.Kind]
+	}
+}
+
+// ParseType turns a type annotation like `int` into a Type
+func ParseType(name string) (Type, bool) {
+	for kind, str := range TypeKindToStr {
+		if str == name && kind != UnknownType {
+			return Type{Kind: kind}, true
+		}
+	}
+
+	return Type{}, false
+}
+
+func listOf(elem Type) Type { return Type{Kind: ListType, Elem: &elem} }
+func mapOf(elem Type) Type  { return Type{Kind: MapType, Elem: &elem} }
+
+func (t Type) elem() Type {
+	if (t.Kind != ListType && t.Kind != MapType) || t.Elem == nil {
+		return Type{Kind: UnknownType}
+	}
+	return *t.Elem
+}
+
+// compatible is true unless both types are known and differ
+func compatible(a, b Type) bool {
+	if a.Kind == UnknownType || b.Kind == UnknownType {
+		return true
+	}
+	if a.Kind != b.Kind {
+		return false
+	}
+	if a.Kind == ListType || a.Kind == MapType {
+		return compatible(a.elem(), b.elem())
+	}
+	if a.Kind == RecordType && a.Name != "" && b.Name != "" {
+		return a.Name == b.Name
+	}
+
+	return true
+}
+
+// unify gives the type both a and b fit in, falling back to unknown
+func unify(a, b Type) Type {
+	if a.Kind != b.Kind {
+		return Type{Kind: UnknownType}
+	}
+	if a.Kind == ListType || a.Kind == MapType {
+		elem := unify(a.elem(), b.elem())
+		return Type{Kind: a.Kind, Elem: &elem}
+	}
+	if a.Kind == RecordType && a.Name != b.Name {
+		return Type{Kind: RecordType}
+	}
+
+	return a
+}
+
+type TypeError struct {
+	Message string
+}
+
+func (te TypeError) Error() string { return te.Message }
+
+// TypeChecker walks the AST without running it, inferring a type for each
+// expression and recording every mismatch it can prove
+type TypeChecker struct {
+	env     map[string]Type
+	records map[string]map[string]Type // record name -> field types
+	Errors  []TypeError
+}
+
+func NewTypeChecker() *TypeChecker {
+	env := map[string]Type{}
+	for name, t := range builtinTypes {
+		env[name] = t
+	}
+
+	return &TypeChecker{env: env, records: map[string]map[string]Type{}}
+}
+
+func builtinType(ret TypeKind, params ...TypeKind) Type {
+	t := Type{Kind: FunctionType, Return: &Type{Kind: ret}}
+	for _, param := range params {
+		t.Params = append(t.Params, Type{Kind: param})
+	}
+	return t
+}
+
+// builtinTypes are the signatures of the builtin functions
+var builtinTypes = map[string]Type{
+	"len":             builtinType(IntType, UnknownType),
+	"substr":          builtinType(StringType, StringType, IntType, IntType),
+	"split":           {Kind: FunctionType, Params: []Type{{Kind: StringType}, {Kind: StringType}}, Return: &Type{Kind: ListType, Elem: &Type{Kind: StringType}}},
+	"join":            builtinType(StringType, ListType, StringType),
+	"upper":           builtinType(StringType, StringType),
+	"lower":           builtinType(StringType, StringType),
+	"trim":            builtinType(StringType, StringType),
+	"contains":        builtinType(BoolType, StringType, StringType),
+	"replace":         builtinType(StringType, StringType, StringType, StringType),
+	"repeat":          builtinType(StringType, StringType, IntType),
+	"int":             builtinType(IntType, UnknownType),
+	"str":             builtinType(StringType, UnknownType),
+	"assert":          {Kind: FunctionType, Params: []Type{{Kind: BoolType}}, Variadic: true, Return: &Type{Kind: NoneType}},
+	"assert_eq":       builtinType(NoneType, UnknownType, UnknownType),
+	"assert_left_of":  builtinType(NoneType, LayoutType, LayoutType),
+	"assert_right_of": builtinType(NoneType, LayoutType, LayoutType),
+	"assert_above":    builtinType(NoneType, LayoutType, LayoutType),
+	"assert_below":    builtinType(NoneType, LayoutType, LayoutType),
+	"format":          {Kind: FunctionType, Params: []Type{{Kind: StringType}}, Variadic: true, Return: &Type{Kind: StringType}},
+	"explain":         builtinType(StringType, LayoutType),
+	"span":            builtinType(LayoutType, LayoutType, IntType, IntType),
+}
+
+// Check runs the type checker over a whole program
+func Check(program Expression) []TypeError {
+	tc := NewTypeChecker()
+	tc.Infer(program)
+
+	return tc.Errors
+}
+
+func (tc *TypeChecker) errorf(format string, args ...any) {
+	tc.Errors = append(tc.Errors, TypeError{Message: fmt.Sprintf(format, args...)})
+}
+
+// expect infers e and reports it if it can't be of the wanted kind
+func (tc *TypeChecker) expect(e Expression, want TypeKind, context string) Type {
+	got := tc.Infer(e)
+	if !compatible(got, Type{Kind: want}) {
+		tc.errorf("%s expects %s, got %s in `%s`", context, TypeKindToStr[want], got, e)
+	}
+
+	return got
+}
+
+// expectCollection is expect for things that work on both lists and maps
+func (tc *TypeChecker) expectCollection(e Expression, context string) Type {
+	got := tc.Infer(e)
+	if !compatible(got, Type{Kind: ListType}) && !compatible(got, Type{Kind: MapType}) {
+		tc.errorf("%s expects list or map, got %s in `%s`", context, got, e)
+	}
+
+	return got
+}
+
+func (tc *TypeChecker) Infer(e Expression) Type {
+	switch e := e.(type) {
+	case IntLiteral:
+		return Type{Kind: IntType}
+	case StringLiteral:
+		return Type{Kind: StringType}
+	case BooleanLiteral:
+		return Type{Kind: BoolType}
+
+	case Assign:
+		tc.env[e.Name] = tc.Infer(e.Expr)
+		return Type{Kind: NoneType}
+
+	case Block:
+		last := Type{Kind: NoneType}
+		for _, expr := range e.Exprs {
+			last = tc.Infer(expr)
+		}
+		return last
+
+	case Dereference:
+		t, ok := tc.env[e.Name]
+		if !ok {
+			// might be assigned somewhere the checker doesn't follow
+			return Type{Kind: UnknownType}
+		}
+		return t
+
+	case Arithmetic:
+		left, right := tc.Infer(e.Left), tc.Infer(e.Right)
+		if !compatible(left, Type{Kind: IntType}) || !compatible(right, Type{Kind: IntType}) {
+			tc.errorf("operator %s expects int operands, got %s and %s in `%s`", ArithOpToStr[e.Op], left, right, e)
+		}
+		return Type{Kind: IntType}
+
+	case Negate:
+		tc.expect(e.Expr, IntType, "unary -")
+		return Type{Kind: IntType}
+
+	case Compare:
+		left, right := tc.Infer(e.Left), tc.Infer(e.Right)
+		switch e.Op {
+		case AND, OR:
+			if !compatible(left, Type{Kind: BoolType}) || !compatible(right, Type{Kind: BoolType}) {
+				tc.errorf("operator %s expects bool operands, got %s and %s in `%s`", CmpOpToStr[e.Op], left, right, e)
+			}
+		case LT, GT, LTE, GTE:
+			if !compatible(left, right) || (!compatible(left, Type{Kind: IntType}) && !compatible(left, Type{Kind: StringType})) {
+				tc.errorf("operator %s expects two ints or two strings, got %s and %s in `%s`", CmpOpToStr[e.Op], left, right, e)
+			}
+		default:
+			if !compatible(left, right) {
+				tc.errorf("operator %s can't compare %s with %s in `%s`", CmpOpToStr[e.Op], left, right, e)
+			}
+		}
+		return Type{Kind: BoolType}
+
+	case Not:
+		tc.expect(e.Expr, BoolType, "not")
+		return Type{Kind: BoolType}
+
+	case Concat:
+		tc.expect(e.Left, StringType, "++")
+		tc.expect(e.Right, StringType, "++")
+		return Type{Kind: StringType}
+
+	case Loop:
+		tc.expect(e.Start, IntType, "for loop start")
+		tc.expect(e.Stop, IntType, "for loop stop")
+		tc.expect(e.Step, IntType, "for loop step")
+		tc.loop(e.Iterator, Type{Kind: IntType}, e.Body)
+		return Type{Kind: NoneType}
+
+	case ForEach:
+		iterable := tc.expectCollection(e.Iterable, "for loop")
+		iterator := Type{Kind: UnknownType}
+		switch iterable.Kind {
+		case ListType:
+			iterator = iterable.elem()
+		case MapType:
+			iterator = Type{Kind: StringType}
+		}
+		tc.loop(e.Iterator, iterator, e.Body)
+		return Type{Kind: NoneType}
+
+	case RecordDecl:
+		fields := map[string]Type{}
+		constructor := Type{Kind: FunctionType, Return: &Type{Kind: RecordType, Name: e.Name}}
+		for i, field := range e.Fields {
+			fieldType := Type{Kind: UnknownType}
+			if i < len(e.FieldTypes) && e.FieldTypes[i] != "" {
+				annotated, ok := ParseType(e.FieldTypes[i])
+				if !ok {
+					tc.errorf("record %s has unknown type %s for field %s", e.Name, e.FieldTypes[i], field)
+				}
+				fieldType = annotated
+			}
+			fields[field] = fieldType
+			constructor.Params = append(constructor.Params, fieldType)
+		}
+		tc.records[e.Name] = fields
+		tc.env[e.Name] = constructor
+		return Type{Kind: NoneType}
+
+	case TestBlock:
+		tc.Infer(e.Body)
+		return Type{Kind: NoneType}
+
+	case Import:
+		// the checker doesn't follow imports, so anything from a module is unknown
+		tc.env[e.Alias] = Type{Kind: UnknownType}
+		return Type{Kind: NoneType}
+
+	case FieldAccess:
+		return tc.inferField(e)
+
+	case Index:
+		target := tc.Infer(e.Target)
+		switch target.Kind {
+		case ListType:
+			tc.expect(e.Position, IntType, "index")
+			return target.elem()
+		case StringType:
+			tc.expect(e.Position, IntType, "index")
+			return Type{Kind: StringType}
+		case MapType:
+			tc.expect(e.Position, StringType, "map key")
+			return target.elem()
+		case UnknownType:
+			tc.Infer(e.Position)
+			return Type{Kind: UnknownType}
+		}
+		tc.errorf("can't index %s in `%s`", target, e)
+		return Type{Kind: UnknownType}
+
+	case Slice:
+		target := tc.Infer(e.Target)
+		for _, bound := range []Expression{e.Start, e.Stop} {
+			if bound != nil {
+				tc.expect(bound, IntType, "slice")
+			}
+		}
+		if target.Kind != ListType && target.Kind != StringType && target.Kind != UnknownType {
+			tc.errorf("can't slice %s in `%s`", target, e)
+		}
+		return target
+
+	case FieldAssign:
+		field := tc.inferField(FieldAccess{Receiver: e.Receiver, Field: e.Field})
+		value := tc.Infer(e.Value)
+		if !compatible(field, value) {
+			tc.errorf("field %s is %s, can't assign %s in `%s`", e.Field, field, value, e)
+		}
+		return Type{Kind: NoneType}
+
+	case IndexAssign:
+		tc.Infer(Index{Target: e.Target, Position: e.Position})
+		tc.Infer(e.Value)
+		return Type{Kind: NoneType}
+
+	case MethodCall:
+		return tc.inferMethod(e)
+
+	case Print:
+		for _, arg := range e.Args {
+			tc.Infer(arg)
+		}
+		return Type{Kind: NoneType}
+
+	case Declare:
+		return tc.inferDeclare(e)
+
+	case FunctionCall:
+		return tc.inferCall(e)
+
+	case IfElifElse:
+		tc.expect(e.If.Condition, BoolType, "if condition")
+		bodies := []Expression{e.If.Body}
+		for _, elseIf := range e.ElseIf {
+			tc.expect(elseIf.Condition, BoolType, "elif condition")
+			bodies = append(bodies, elseIf.Body)
+		}
+		if e.Else != nil {
+			bodies = append(bodies, e.Else)
+		}
+
+		results := tc.branches(e.Else != nil, bodies...)
+		if e.Else == nil {
+			return Type{Kind: UnknownType}
+		}
+		result := results[0]
+		for _, t := range results[1:] {
+			result = unify(result, t)
+		}
+		return result
+
+	case List:
+		if len(e.Values) == 0 {
+			return listOf(Type{Kind: UnknownType})
+		}
+		elem := tc.Infer(e.Values[0])
+		for _, value := range e.Values[1:] {
+			elem = unify(elem, tc.Infer(value))
+		}
+		return listOf(elem)
+
+	case Map:
+		elem := Type{Kind: UnknownType}
+		for i := range e.Keys {
+			tc.expect(e.Keys[i], StringType, "map key")
+			if i == 0 {
+				elem = tc.Infer(e.Values[i])
+			} else {
+				elem = unify(elem, tc.Infer(e.Values[i]))
+			}
+		}
+		return mapOf(elem)
+
+	case BoxExpr:
+		return Type{Kind: LayoutType}
+
+	case GroupExpr:
+		items := tc.expect(e.Items, ListType, "Group")
+		if !compatible(items.elem(), Type{Kind: LayoutType}) {
+			tc.errorf("Group expects a list of layout items, got %s in `%s`", items, e.Items)
+		}
+		for _, c := range e.Constraints {
+			for _, name := range []string{c.LeftItemName, c.RightItemName} {
+				t := tc.Infer(Dereference{Name: strings.Trim(name, "*")})
+				if t.Kind == FunctionType && t.Return != nil {
+					t = *t.Return
+				}
+				if !compatible(t, Type{Kind: LayoutType}) {
+					tc.errorf("constraint item %s must be a layout item, got %s", name, t)
+				}
+			}
+		}
+		return Type{Kind: LayoutType}
+
+	case StackExpr:
+		kind := stackKind(e.Horizontal)
+		items := tc.expect(e.Items, ListType, kind)
+		if !compatible(items.elem(), Type{Kind: LayoutType}) {
+			tc.errorf("%s expects a list of layout items, got %s in `%s`", kind, items, e.Items)
+		}
+		if e.Spacing != nil {
+			tc.expect(e.Spacing, IntType, kind+" spacing")
+		}
+		if e.Alignment != nil {
+			tc.expect(e.Alignment, StringType, kind+" alignment")
+		}
+		return Type{Kind: LayoutType}
+
+	case GridExpr:
+		items := tc.expect(e.Items, ListType, "Grid")
+		if !compatible(items.elem(), Type{Kind: LayoutType}) {
+			tc.errorf("Grid expects a list of layout items, got %s in `%s`", items, e.Items)
+		}
+		tc.expect(e.Columns, IntType, "Grid columns")
+		if e.Gap != nil {
+			tc.expect(e.Gap, IntType, "Grid gap")
+		}
+		return Type{Kind: LayoutType}
+
+	case Htmlify:
+		tc.expect(e.Layout, LayoutType, ".htmlify")
+		return Type{Kind: NoneType}
+
+	default:
+		return Type{Kind: UnknownType}
+	}
+}
+
+// loop checks a loop body with iterator bound to t, the body might not run
+// so it's a branch, and whatever iterator shadowed is back afterwards
+func (tc *TypeChecker) loop(iterator string, t Type, body Expression) {
+	previous, shadowed := tc.env[iterator]
+	tc.env[iterator] = t
+	tc.branches(false, body)
+
+	if shadowed {
+		tc.env[iterator] = previous
+	} else {
+		delete(tc.env, iterator)
+	}
+}
+
+// branches infers each body in its own copy of the environment, only one of
+// them runs (or none when exhaustive is false). Afterwards a variable has the
+// type every way through agrees on, unknown if they differ or it might be unset
+func (tc *TypeChecker) branches(exhaustive bool, bodies ...Expression) []Type {
+	before := tc.env
+	var results []Type
+	var envs []map[string]Type
+	for _, body := range bodies {
+		tc.env = util.DeepCopyMap(before)
+		results = append(results, tc.Infer(body))
+		envs = append(envs, tc.env)
+	}
+	if !exhaustive {
+		envs = append(envs, before)
+	}
+
+	merged := map[string]Type{}
+	for _, env := range envs {
+		for name := range env {
+			if _, done := merged[name]; done {
+				continue
+			}
+
+			t := env[name]
+			for _, other := range envs {
+				otherType, ok := other[name]
+				if !ok {
+					t = Type{Kind: UnknownType}
+					break
+				}
+				t = unify(t, otherType)
+			}
+			merged[name] = t
+		}
+	}
+	tc.env = merged
+
+	return results
+}
+
+func (tc *TypeChecker) inferDeclare(d Declare) Type {
+	fn := Type{Kind: FunctionType, Return: &Type{Kind: UnknownType}}
+	for i := range d.Args {
+		param := Type{Kind: UnknownType}
+		if i < len(d.ArgTypes) && d.ArgTypes[i] != "" {
+			annotated, ok := ParseType(d.ArgTypes[i])
+			if !ok {
+				tc.errorf("function %s has unknown type %s for parameter %s", d.Name, d.ArgTypes[i], d.Args[i])
+			}
+			param = annotated
+		}
+		fn.Params = append(fn.Params, param)
+	}
+
+	// bind before checking the body so recursive calls resolve
+	tc.env[d.Name] = fn
+
+	outsideScope := tc.env
+	tc.env = map[string]Type{}
+	for name, t := range outsideScope {
+		tc.env[name] = t
+	}
+	for i, arg := range d.Args {
+		tc.env[arg] = fn.Params[i]
+	}
+
+	ret := tc.Infer(d.Body)
+	fn.Return = &ret
+
+	tc.env = outsideScope
+	tc.env[d.Name] = fn
+
+	return Type{Kind: NoneType}
+}
+
+func (tc *TypeChecker) inferCall(fc FunctionCall) Type {
+	var args []Type
+	for _, arg := range fc.Args {
+		args = append(args, tc.Infer(arg))
+	}
+
+	fn, ok := tc.env[fc.Name]
+	if !ok || fn.Kind == UnknownType {
+		return Type{Kind: UnknownType}
+	}
+	if fn.Kind != FunctionType {
+		tc.errorf("%s is %s, not a function", fc.Name, fn)
+		return Type{Kind: UnknownType}
+	}
+	if len(fn.Params) != len(args) && !(fn.Variadic && len(args) >= len(fn.Params)) {
+		tc.errorf("function %s expects %d args got %d", fc.Name, len(fn.Params), len(args))
+	}
+
+	for i := 0; i < len(fn.Params) && i < len(args); i++ {
+		if !compatible(fn.Params[i], args[i]) {
+			tc.errorf("argument %d of %s expects %s, got %s in `%s`", i+1, fc.Name, fn.Params[i], args[i], fc.Args[i])
+		}
+	}
+
+	if fn.Return == nil {
+		return Type{Kind: UnknownType}
+	}
+	return *fn.Return
+}
+
+func (tc *TypeChecker) inferField(fa FieldAccess) Type {
+	receiver := tc.Infer(fa.Receiver)
+
+	switch receiver.Kind {
+	case UnknownType:
+		return Type{Kind: UnknownType}
+	case RecordType:
+		fields, ok := tc.records[receiver.Name]
+		if !ok {
+			return Type{Kind: UnknownType}
+		}
+		field, ok := fields[fa.Field]
+		if !ok {
+			tc.errorf("record %s has no field %s in `%s`", receiver.Name, fa.Field, fa)
+			return Type{Kind: UnknownType}
+		}
+		return field
+	case ListType, MapType:
+		if fa.Field == "len" {
+			return Type{Kind: IntType}
+		}
+		if fa.Field == "keys" && receiver.Kind == MapType {
+			return listOf(Type{Kind: StringType})
+		}
+	}
+
+	tc.errorf("%s has no field %s in `%s`", receiver, fa.Field, fa)
+	return Type{Kind: UnknownType}
+}
+
+func (tc *TypeChecker) inferMethod(mc MethodCall) Type {
+	receiver := tc.Infer(mc.Receiver)
+
+	var args []Type
+	for _, arg := range mc.Args {
+		args = append(args, tc.Infer(arg))
+	}
+	arg := func(i int) Type {
+		if i < len(args) {
+			return args[i]
+		}
+		return Type{Kind: UnknownType}
+	}
+	expectArg := func(i int, want Type) {
+		if !compatible(arg(i), want) {
+			tc.errorf("argument %d of .%s expects %s, got %s in `%s`", i+1, mc.Name, want, arg(i), mc)
+		}
+	}
+
+	// returns the type of function argument i when called
+	returnOf := func(i int) Type {
+		if f := arg(i); f.Kind == FunctionType && f.Return != nil {
+			return *f.Return
+		}
+		return Type{Kind: UnknownType}
+	}
+
+	switch receiver.Kind {
+	case ListType:
+		switch mc.Name {
+		case "get":
+			expectArg(0, Type{Kind: IntType})
+			return receiver.elem()
+		case "add":
+			return listOf(unify(receiver.elem(), arg(0)))
+		case "insert", "set":
+			expectArg(0, Type{Kind: IntType})
+			return listOf(unify(receiver.elem(), arg(1)))
+		case "del":
+			expectArg(0, Type{Kind: IntType})
+			return receiver
+		case "slice":
+			expectArg(0, Type{Kind: IntType})
+			expectArg(1, Type{Kind: IntType})
+			return receiver
+		case "reverse", "sort", "filter":
+			return receiver
+		case "contains":
+			return Type{Kind: BoolType}
+		case "index_of":
+			return Type{Kind: IntType}
+		case "concat":
+			expectArg(0, Type{Kind: ListType})
+			return listOf(unify(receiver.elem(), arg(0).elem()))
+		case "map":
+			expectArg(0, Type{Kind: FunctionType})
+			return listOf(returnOf(0))
+		case "reduce":
+			expectArg(0, Type{Kind: FunctionType})
+			return unify(returnOf(0), arg(1))
+		}
+	case MapType:
+		switch mc.Name {
+		case "get":
+			expectArg(0, Type{Kind: StringType})
+			return receiver.elem()
+		case "set":
+			expectArg(0, Type{Kind: StringType})
+			return mapOf(unify(receiver.elem(), arg(1)))
+		case "has":
+			expectArg(0, Type{Kind: StringType})
+			return Type{Kind: BoolType}
+		case "del":
+			expectArg(0, Type{Kind: StringType})
+			return receiver
+		}
+	case UnknownType, RecordType:
+		return Type{Kind: UnknownType}
+	}
+
+	tc.errorf("%s has no method %s in `%s`", receiver, mc.Name, mc)
+	return Type{Kind: UnknownType}
+}
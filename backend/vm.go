@@ -0,0 +1,461 @@
+package backend
+
+import "fmt"
+
+// RunCompiled runs bytecode from Compile against the runtime, it behaves like
+// evaluating the program with Eval but doesn't walk the tree for the common
+// expressions
+func (r Runtime) RunCompiled(c *Chunk) Data {
+	m := vm{rt: r}
+	return m.run(c, &frame{vars: r.SymbolTable})
+}
+
+type vm struct {
+	rt Runtime
+}
+
+type slotState byte
+
+const (
+	unset slotState = iota
+	set
+	deleted
+)
+
+// frame holds the variables of one function call with arguments, the global
+// frame's vars are the runtime's SymbolTable. Calls can see their callers
+// variables (like SubScope) so lookups that miss go to the parent, writes
+// never do
+type frame struct {
+	parent *frame
+
+	slotIndex map[string]int
+	slots     []Data
+	state     []slotState
+
+	vars    map[string]Data
+	deleted map[string]bool // names removed here that the parent might still have
+}
+
+func (f *frame) lookup(name string, slot int) (Data, bool) {
+	if slot >= 0 && f.state[slot] != unset {
+		return f.slots[slot], f.state[slot] == set
+	}
+
+	for fr := f; fr != nil; fr = fr.parent {
+		if i, ok := fr.slotIndex[name]; ok && fr.state[i] != unset {
+			return fr.slots[i], fr.state[i] == set
+		}
+		if data, ok := fr.vars[name]; ok {
+			return data, true
+		}
+		if fr.deleted[name] {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+func (f *frame) store(name string, slot int, data Data) {
+	if slot < 0 {
+		if i, ok := f.slotIndex[name]; ok {
+			slot = i
+		}
+	}
+	if slot >= 0 {
+		f.slots[slot], f.state[slot] = data, set
+		return
+	}
+
+	f.vars[name] = data
+	delete(f.deleted, name)
+}
+
+func (f *frame) remove(name string, slot int) {
+	if slot < 0 {
+		if i, ok := f.slotIndex[name]; ok {
+			slot = i
+		}
+	}
+	if slot >= 0 {
+		f.slots[slot], f.state[slot] = nil, deleted
+		return
+	}
+
+	delete(f.vars, name)
+	if f.parent != nil {
+		f.deleted[name] = true
+	}
+}
+
+// table flattens the frame and its parents into one symbol table
+func (f *frame) table() map[string]Data {
+	if f.parent == nil {
+		return f.vars
+	}
+
+	table := map[string]Data{}
+	for name, data := range f.parent.table() {
+		table[name] = data
+	}
+	for name := range f.deleted {
+		delete(table, name)
+	}
+	for name, data := range f.vars {
+		table[name] = data
+	}
+	for i, name := range f.slots2names() {
+		switch f.state[i] {
+		case set:
+			table[name] = f.slots[i]
+		case deleted:
+			delete(table, name)
+		}
+	}
+
+	return table
+}
+
+func (f *frame) slots2names() []string {
+	names := make([]string, len(f.slots))
+	for name, i := range f.slotIndex {
+		names[i] = name
+	}
+	return names
+}
+
+// interpret runs fn with a runtime whose SymbolTable holds the frame's
+// variables, for the parts of the language the vm leaves to Eval. Anything
+// fn changes is copied back into the frame
+func (m *vm) interpret(f *frame, fn func(r Runtime) Data) Data {
+	if f.parent == nil {
+		return fn(m.rt)
+	}
+
+	r := m.rt
+	r.SymbolTable = f.table()
+
+	before := map[string]bool{}
+	for name := range r.SymbolTable {
+		before[name] = true
+	}
+
+	defer func() {
+		for name, data := range r.SymbolTable {
+			f.store(name, -1, data)
+		}
+		for name := range before {
+			if _, ok := r.SymbolTable[name]; !ok {
+				f.remove(name, -1)
+			}
+		}
+	}()
+
+	return fn(r)
+}
+
+// iterator is what a for loop goes through, it only ever lives on the stack
+type iterator struct {
+	values []Data
+	next   int
+
+	ranged         bool
+	at, stop, step int
+}
+
+func (it *iterator) String() string { return "iterator" }
+
+func (it *iterator) advance() (Data, bool) {
+	if it.ranged {
+		if (it.step < 0 && it.at <= it.stop) || (it.step >= 0 && it.at >= it.stop) {
+			return nil, false
+		}
+		i := it.at
+		it.at += it.step
+		return IntData{Value: i, Literal: fmt.Sprintf("%d", i)}, true
+	}
+
+	if it.next >= len(it.values) {
+		return nil, false
+	}
+	it.next++
+	return it.values[it.next-1], true
+}
+
+func (m *vm) run(c *Chunk, f *frame) Data {
+	var stack []Data
+	push := func(data Data) { stack = append(stack, data) }
+	pop := func() Data {
+		data := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return data
+	}
+	popN := func(n int) []Data {
+		values := append([]Data(nil), stack[len(stack)-n:]...)
+		stack = stack[:len(stack)-n]
+		return values
+	}
+
+	for pc := 0; pc < len(c.code); pc++ {
+		if m.rt.budget != nil {
+			m.rt.budget.step()
+		}
+
+		in := c.code[pc]
+		switch in.op {
+		case opConst:
+			push(c.constants[in.a])
+		case opPop:
+			pop()
+
+		case opLoad:
+			data, ok := f.lookup(c.names[in.a], in.b)
+			if !ok {
+				data, ok = m.rt.Natives[c.names[in.a]]
+			}
+			if !ok {
+				raise("Attempt to dereference uninitialized variable %s", c.names[in.a])
+			}
+			push(data)
+		case opStore:
+			f.store(c.names[in.a], in.b, pop())
+		case opDelete:
+			f.remove(c.names[in.a], in.b)
+
+		case opFunc:
+			function, ok := f.lookup(c.names[in.a], -1)
+			if !ok {
+				function, ok = m.rt.Natives[c.names[in.a]]
+			}
+			if !ok {
+				raise("function %s doesn't exist", c.names[in.a])
+			}
+			push(function)
+		case opCall:
+			frame := c.nodes[in.b].(FunctionCall).frame(m.rt, popN(in.a))
+			push(m.call(f, frame, pop()))
+		case opTailCall:
+			frame := c.nodes[in.b].(FunctionCall).frame(m.rt, popN(in.a))
+			function := pop()
+			if funcData, ok := function.(FunctionData); ok {
+				return &vmTailCall{caller: f, frame: frame, function: funcData}
+			}
+			push(m.call(f, frame, function))
+
+		case opArith:
+			right := pop()
+			push(c.nodes[in.a].(Arithmetic).apply(pop(), right))
+		case opNegate:
+			push(c.nodes[in.a].(Negate).apply(pop()))
+		case opNot:
+			push(c.nodes[in.a].(Not).apply(pop()))
+		case opConcat:
+			right := pop()
+			push(c.nodes[in.a].(Concat).apply(m.rt, pop(), right))
+		case opCompare:
+			right := pop()
+			push(c.nodes[in.a].(Compare).apply(pop(), right))
+		case opLogical:
+			side := "left"
+			if in.b == 1 {
+				side = "right"
+			}
+			push(c.nodes[in.a].(Compare).operand(pop(), side))
+
+		case opJump:
+			pc = in.a - 1
+		case opJumpIf:
+			if stack[len(stack)-1].(BooleanData).Value == (in.b != 0) {
+				pc = in.a - 1
+			} else {
+				pop()
+			}
+		case opJumpFalse:
+			condition, ok := pop().(BooleanData)
+			if !ok {
+				raise("%s", c.constants[in.b].(StringData).Value)
+			}
+			if !condition.Value {
+				pc = in.a - 1
+			}
+
+		case opListSize:
+			checkListSize(m.rt, in.a)
+		case opList:
+			var list ListData
+			list.Values = append(list.Values, popN(in.a)...)
+			push(list)
+		case opMapKey:
+			mapKey(stack[len(stack)-1])
+		case opMap:
+			pairs := popN(in.a * 2)
+			mapData := NewMapData()
+			for i := 0; i < len(pairs); i += 2 {
+				mapData = mapData.Set(mapKey(pairs[i]), pairs[i+1])
+			}
+			push(mapData)
+
+		case opMethod:
+			args := popN(in.b)
+			push(m.method(f, c.nodes[in.a].(MethodCall), pop(), args))
+		case opField:
+			push(c.nodes[in.a].(FieldAccess).apply(pop()))
+		case opIndex:
+			position := pop()
+			push(c.nodes[in.a].(Index).apply(pop(), position))
+		case opPrint:
+			push(c.nodes[in.a].(Print).write(m.rt, popN(in.b)))
+
+		case opRange:
+			bounds := popN(3)
+			start, stop, step := c.nodes[in.a].(Loop).bounds(bounds[0], bounds[1], bounds[2])
+			push(&iterator{ranged: true, at: start, stop: stop, step: step})
+		case opIter:
+			push(&iterator{values: items(pop())})
+		case opNext:
+			next, ok := stack[len(stack)-1].(*iterator).advance()
+			if !ok {
+				pop()
+				pc = in.a - 1
+				break
+			}
+			push(next)
+
+		case opEval:
+			node := c.nodes[in.a]
+			if in.b == 1 {
+				push(node.Eval(m.rt))
+				break
+			}
+			push(m.interpret(f, func(r Runtime) Data { return node.Eval(r) }))
+
+		default:
+			panic(fmt.Sprintf("unknown opcode %d", in.op))
+		}
+	}
+
+	if len(stack) == 0 {
+		return nil
+	}
+	return stack[len(stack)-1]
+}
+
+// vmTailCall is the vm's tailCall, run returns it to call which makes the call
+type vmTailCall struct {
+	caller   *frame // where the call was made
+	frame    StackFrame
+	function FunctionData
+}
+
+func (tc *vmTailCall) String() string { return fmt.Sprintf("tailCall:%s", tc.frame.Function) }
+
+// call is the vm version of call, compiled functions run on the vm and
+// everything else is handed to the interpreter's call
+func (m *vm) call(f *frame, stackFrame StackFrame, function Data) Data {
+	funcData, ok := function.(FunctionData)
+	if !ok {
+		return callFrame(m.rt, stackFrame, function)
+	}
+
+	m.rt.calls.enter(m.rt, stackFrame)
+	defer m.rt.calls.leave(m.rt)
+	defer m.rt.calls.annotate()
+
+	// the frames of functions that finished with a tail call are folded into
+	// one, so lookups see what they would have without the chain growing
+	var finished *frame
+	for {
+		args := stackFrame.Args
+		if len(funcData.Args) != len(args) {
+			raise("function %s expects %d args got %d", stackFrame.Function, len(funcData.Args), len(args))
+		}
+
+		code := funcData.compiled()
+
+		// like call, functions without arguments run in their callers scope
+		callee, owned := f, len(args) > 0
+		if owned {
+			callee = &frame{
+				parent:    f,
+				slotIndex: code.slotIndex,
+				slots:     make([]Data, len(code.slots)),
+				state:     make([]slotState, len(code.slots)),
+				vars:      map[string]Data{},
+				deleted:   map[string]bool{},
+			}
+			for i, arg := range args {
+				callee.store(funcData.Args[i], -1, arg)
+			}
+		}
+
+		result := m.run(code, callee)
+		next, ok := result.(*vmTailCall)
+		if !ok {
+			return result
+		}
+
+		f, stackFrame, funcData = next.caller, next.frame, next.function
+		if owned && f == callee {
+			if finished == nil || callee.parent != finished {
+				finished = &frame{parent: callee.parent, vars: map[string]Data{}, deleted: map[string]bool{}}
+			}
+			finished.absorb(callee)
+			f = finished
+		}
+		m.rt.calls.replace(m.rt, stackFrame)
+	}
+}
+
+// absorb copies the variables f set or deleted itself into fr
+func (fr *frame) absorb(f *frame) {
+	for name := range f.deleted {
+		fr.remove(name, -1)
+	}
+	for name, data := range f.vars {
+		fr.store(name, -1, data)
+	}
+	for i, name := range f.slots2names() {
+		switch f.state[i] {
+		case set:
+			fr.store(name, -1, f.slots[i])
+		case deleted:
+			fr.remove(name, -1)
+		}
+	}
+}
+
+func (m *vm) method(f *frame, mc MethodCall, receiver Data, args []Data) Data {
+	needsScope := false
+	switch receiver.(type) {
+	case RecordData, ModuleData:
+		needsScope = true
+	}
+	for _, arg := range args {
+		if _, ok := arg.(FunctionData); ok {
+			needsScope = true
+		}
+	}
+
+	var result Data
+	var mutates bool
+	if needsScope {
+		result = m.interpret(f, func(r Runtime) Data {
+			result, mutates := mc.apply(r, receiver, args)
+			if mutates {
+				if target, ok := mc.Receiver.(Dereference); ok {
+					r.SymbolTable[target.Name] = result
+				}
+			}
+			return result
+		})
+		return result
+	}
+
+	result, mutates = mc.apply(m.rt, receiver, args)
+	if target, ok := mc.Receiver.(Dereference); ok && mutates {
+		f.store(target.Name, -1, result)
+	}
+
+	return result
+}
@@ -0,0 +1,103 @@
+package backend
+
+// children are the sub expressions of e in evaluation order, function and
+// test bodies included
+func children(e Expression) []Expression {
+	switch e := e.(type) {
+	case Assign:
+		return []Expression{e.Expr}
+	case Block:
+		return e.Exprs
+	case Arithmetic:
+		return []Expression{e.Left, e.Right}
+	case Negate:
+		return []Expression{e.Expr}
+	case Compare:
+		return []Expression{e.Left, e.Right}
+	case Not:
+		return []Expression{e.Expr}
+	case Concat:
+		return []Expression{e.Left, e.Right}
+	case Loop:
+		return []Expression{e.Start, e.Stop, e.Step, e.Body}
+	case ForEach:
+		return []Expression{e.Iterable, e.Body}
+	case Print:
+		return e.Args
+	case Declare:
+		return []Expression{e.Body}
+	case FunctionCall:
+		return e.Args
+	case IfElifElse:
+		exprs := []Expression{e.If.Condition, e.If.Body}
+		for _, elseIf := range e.ElseIf {
+			exprs = append(exprs, elseIf.Condition, elseIf.Body)
+		}
+		if e.Else != nil {
+			exprs = append(exprs, e.Else)
+		}
+		return exprs
+	case List:
+		return e.Values
+	case Map:
+		var exprs []Expression
+		for i := range e.Keys {
+			exprs = append(exprs, e.Keys[i], e.Values[i])
+		}
+		return exprs
+	case MethodCall:
+		return append([]Expression{e.Receiver}, e.Args...)
+	case FieldAccess:
+		return []Expression{e.Receiver}
+	case FieldAssign:
+		return []Expression{e.Value, e.Receiver}
+	case Index:
+		return []Expression{e.Target, e.Position}
+	case Slice:
+		exprs := []Expression{e.Target}
+		if e.Start != nil {
+			exprs = append(exprs, e.Start)
+		}
+		if e.Stop != nil {
+			exprs = append(exprs, e.Stop)
+		}
+		return exprs
+	case IndexAssign:
+		return []Expression{e.Value, e.Position, e.Target}
+	case GroupExpr:
+		return []Expression{e.Items}
+	case StackExpr:
+		exprs := []Expression{e.Items}
+		if e.Spacing != nil {
+			exprs = append(exprs, e.Spacing)
+		}
+		if e.Alignment != nil {
+			exprs = append(exprs, e.Alignment)
+		}
+		return exprs
+	case GridExpr:
+		exprs := []Expression{e.Items, e.Columns}
+		if e.Gap != nil {
+			exprs = append(exprs, e.Gap)
+		}
+		return exprs
+	case Htmlify:
+		return []Expression{e.Layout}
+	case TestBlock:
+		return []Expression{e.Body}
+	default:
+		return nil
+	}
+}
+
+// walk calls visit on e and everything under it, depth first
+func walk(e Expression, visit func(Expression)) {
+	if e == nil {
+		return
+	}
+
+	visit(e)
+	for _, child := range children(e) {
+		walk(child, visit)
+	}
+}
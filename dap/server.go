@@ -0,0 +1,580 @@
+// Package dap is a debug adapter protocol server, so editors can debug
+// morpheus programs with breakpoints, stepping and variable inspection
+package dap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// threadId is the only thread, programs run on one
+const threadId = 1
+
+// Server debugs one program for one editor session
+type Server struct {
+	// NewRuntime makes the runtime the launched program runs in
+	NewRuntime func(program string) backend.Runtime
+
+	in  *bufio.Reader
+	out io.Writer
+
+	writeMu sync.Mutex
+	seq     int
+
+	debugger *backend.Debugger
+	resume   chan backend.StepMode
+
+	mu          sync.Mutex
+	program     string // absolute path once launched
+	source      string
+	launched    bool
+	configured  bool
+	closed      bool
+	cancel      context.CancelFunc
+	breakpoints map[string][]int // lines by source path
+	stop        *backend.Stop    // nil while the program runs
+	refs        []func() []variable
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	s := &Server{
+		NewRuntime: func(program string) backend.Runtime {
+			rt := backend.NewRuntime()
+			rt.Dir, rt.File = filepath.Dir(program), program
+			return rt
+		},
+		in:          bufio.NewReader(in),
+		out:         out,
+		resume:      make(chan backend.StepMode),
+		breakpoints: map[string][]int{},
+	}
+	s.debugger = backend.NewDebugger(s.onStop)
+
+	return s
+}
+
+// Serve handles requests until the editor disconnects or closes the input
+func (s *Server) Serve() error {
+	defer s.shutdown()
+
+	for {
+		msg, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Type == "request" && s.handle(msg) {
+			return nil
+		}
+	}
+}
+
+// handle answers a request, it's true once the session is over
+func (s *Server) handle(msg message) bool {
+	var body any
+	var err error
+
+	switch msg.Command {
+	case "initialize":
+		body = capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}
+		defer s.event("initialized", nil)
+	case "launch":
+		err = s.launch(msg.Arguments)
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(msg.Arguments)
+	case "configurationDone":
+		s.mu.Lock()
+		s.configured = true
+		s.mu.Unlock()
+		defer s.start()
+	case "threads":
+		body = map[string]any{"threads": []thread{{Id: threadId, Name: "main"}}}
+	case "stackTrace":
+		body, err = s.stackTrace()
+	case "scopes":
+		body, err = s.scopes(msg.Arguments)
+	case "variables":
+		body, err = s.variables(msg.Arguments)
+	case "evaluate":
+		body, err = s.evaluate(msg.Arguments)
+	// the program only resumes once it's been answered, so the editor never
+	// sees it stop again before the response
+	case "continue":
+		defer s.step(backend.Continue)
+		body = map[string]any{"allThreadsContinued": true}
+	case "next":
+		defer s.step(backend.StepOver)
+	case "stepIn":
+		defer s.step(backend.StepInto)
+	case "stepOut":
+		defer s.step(backend.StepOut)
+	case "pause":
+		s.debugger.Pause()
+	case "disconnect", "terminate":
+		s.respond(msg, nil, nil)
+		return true
+	default:
+		err = fmt.Errorf("unsupported request %s", msg.Command)
+	}
+
+	s.respond(msg, body, err)
+	return false
+}
+
+func (s *Server) respond(msg message, body any, err error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.seq++
+	resp := response{Seq: s.seq, Type: "response", RequestSeq: msg.Seq, Success: err == nil, Command: msg.Command, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	writeMessage(s.out, resp)
+}
+
+func (s *Server) event(name string, body any) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.seq++
+	writeMessage(s.out, event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+func (s *Server) launch(arguments json.RawMessage) error {
+	var args launchArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return err
+	}
+
+	program, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+	source, err := os.ReadFile(program)
+	if err != nil {
+		return fmt.Errorf("couldn't read %s", args.Program)
+	}
+
+	s.mu.Lock()
+	s.program, s.source, s.launched = program, string(source), true
+	s.debugger.StopOnEntry = args.StopOnEntry
+	s.mu.Unlock()
+
+	s.start()
+	return nil
+}
+
+// start runs the program once it's launched and the editor has sent its
+// breakpoints
+func (s *Server) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.launched || !s.configured || s.cancel != nil {
+		return
+	}
+	s.applyBreakpoints()
+
+	rt := s.NewRuntime(s.program)
+	if rt.File == "" {
+		// breakpoints in the program are keyed by its path
+		rt.File = s.program
+	}
+	rt.Stdout = output{s: s, category: "stdout"}
+	rt.Hook = s.debugger
+
+	// disconnecting cancels the program
+	ctx, cancel := context.WithCancel(context.Background())
+	rt, _ = rt.WithLimits(ctx, backend.Limits{})
+	s.cancel = cancel
+
+	go s.run(rt, s.source)
+}
+
+func (s *Server) run(rt backend.Runtime, source string) {
+	exitCode := 0
+	if err := exec.RunWith(rt, source); err != nil {
+		exitCode = 1
+		message := fmt.Sprintf("runtime error: %s\n", err)
+		if runtimeErr, ok := err.(backend.RuntimeError); ok {
+			message += runtimeErr.StackTrace()
+		}
+		s.event("output", map[string]any{"category": "stderr", "output": message})
+	}
+
+	s.event("exited", map[string]any{"exitCode": exitCode})
+	s.event("terminated", nil)
+}
+
+// shutdown stops a running program, a paused one is let go so it sees it's
+// been canceled
+func (s *Server) shutdown() {
+	s.mu.Lock()
+	s.closed = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	s.step(backend.Continue)
+}
+
+// output sends what the program prints to the editor
+type output struct {
+	s        *Server
+	category string
+}
+
+func (o output) Write(p []byte) (int, error) {
+	o.s.event("output", map[string]any{"category": o.category, "output": string(p)})
+	return len(p), nil
+}
+
+func (s *Server) setBreakpoints(arguments json.RawMessage) (any, error) {
+	var args setBreakpointsArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines []int
+	breakpoints := []breakpoint{}
+	for _, bp := range args.Breakpoints {
+		lines = append(lines, bp.Line)
+		breakpoints = append(breakpoints, breakpoint{Verified: true, Line: bp.Line})
+	}
+	s.breakpoints[path] = lines
+	s.applyBreakpoints()
+
+	return map[string]any{"breakpoints": breakpoints}, nil
+}
+
+// applyBreakpoints gives the debugger the breakpoints of every file, the
+// program's and the modules it imports
+func (s *Server) applyBreakpoints() {
+	if !s.launched {
+		return
+	}
+
+	for _, bp := range s.debugger.Breakpoints() {
+		s.debugger.SetBreakpoint(bp.File, bp.Line, false)
+	}
+	for path, lines := range s.breakpoints {
+		for _, line := range lines {
+			s.debugger.SetBreakpoint(path, line, true)
+		}
+	}
+}
+
+// onStop tells the editor where the program stopped then waits for it to
+// say how to carry on
+func (s *Server) onStop(stop backend.Stop) backend.StepMode {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return backend.Continue
+	}
+	s.stop, s.refs = &stop, nil
+	s.mu.Unlock()
+
+	s.event("stopped", map[string]any{"reason": stop.Reason, "threadId": threadId, "allThreadsStopped": true})
+
+	return <-s.resume
+}
+
+// step resumes a stopped program
+func (s *Server) step(mode backend.StepMode) {
+	s.mu.Lock()
+	if s.stop == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.stop = nil
+	s.mu.Unlock()
+
+	s.resume <- mode
+}
+
+// stopped is the current stop, s.mu must be held
+func (s *Server) stopped() (*backend.Stop, error) {
+	if s.stop == nil {
+		return nil, fmt.Errorf("the program isn't stopped")
+	}
+	return s.stop, nil
+}
+
+// stackTrace has a frame for every call in the stop's stack then one for the
+// program, frame i's file and line are the call site of the frame inside it
+func (s *Server) stackTrace() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stop, err := s.stopped()
+	if err != nil {
+		return nil, err
+	}
+
+	frames := []stackFrame{}
+	for i := 0; i <= len(stop.Stack); i++ {
+		file := stop.Position.File
+		frame := stackFrame{Id: i + 1, Name: "<program>", Line: stop.Position.Line, Column: stop.Position.Column}
+		if i < len(stop.Stack) {
+			frame.Name = stop.Stack[i].Function
+		}
+		if i > 0 {
+			file, frame.Line, frame.Column = stop.Stack[i-1].File, stop.Stack[i-1].Line, stop.Stack[i-1].Column
+		}
+		if file == "" {
+			file = s.program
+		}
+		frame.Source = &source{Name: filepath.Base(file), Path: file}
+		frames = append(frames, frame)
+	}
+
+	return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// frame is the scope of the frame the editor asked about
+func (s *Server) frame(arguments json.RawMessage) (backend.Runtime, error) {
+	var args struct {
+		FrameId int `json:"frameId"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return backend.Runtime{}, err
+	}
+
+	stop, err := s.stopped()
+	if err != nil {
+		return backend.Runtime{}, err
+	}
+	if args.FrameId < 1 || args.FrameId > len(stop.Scopes) {
+		return backend.Runtime{}, fmt.Errorf("no frame %d", args.FrameId)
+	}
+
+	return stop.Scopes[args.FrameId-1], nil
+}
+
+// scopes are the frame's variables and a layout scope with just the boxes
+// and groups, showing where the solver put them
+func (s *Server) scopes(arguments json.RawMessage) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, err := s.frame(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	table := rt.SymbolTable
+	locals := s.reference(func() []variable {
+		var variables []variable
+		for _, name := range sortedNames(table) {
+			variables = append(variables, s.variable(name, table[name]))
+		}
+		return variables
+	})
+	layout := s.reference(func() []variable {
+		var variables []variable
+		for _, name := range sortedNames(table) {
+			if _, ok := table[name].(backend.LayoutItem); ok {
+				variables = append(variables, s.variable(name, table[name]))
+			}
+		}
+		return variables
+	})
+
+	return map[string]any{"scopes": []scope{
+		{Name: "Locals", PresentationHint: "locals", VariablesReference: locals},
+		{Name: "Layout", VariablesReference: layout},
+	}}, nil
+}
+
+func (s *Server) variables(arguments json.RawMessage) (any, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.stopped(); err != nil {
+		return nil, err
+	}
+	if args.VariablesReference < 1 || args.VariablesReference > len(s.refs) {
+		return nil, fmt.Errorf("no variables %d", args.VariablesReference)
+	}
+
+	variables := s.refs[args.VariablesReference-1]()
+	if variables == nil {
+		variables = []variable{}
+	}
+	return map[string]any{"variables": variables}, nil
+}
+
+// evaluate looks up a variable, which is what hovering over a name asks for
+func (s *Server) evaluate(arguments json.RawMessage) (any, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, err := s.frame(arguments)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := rt.SymbolTable[args.Expression]
+	if !ok {
+		return nil, fmt.Errorf("%s isn't set", args.Expression)
+	}
+
+	v := s.variable(args.Expression, value)
+	return map[string]any{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+}
+
+// reference registers children to be fetched by a variables request, they
+// last until the program resumes
+func (s *Server) reference(children func() []variable) int {
+	s.refs = append(s.refs, children)
+	return len(s.refs)
+}
+
+// variable describes a value, lists, maps, records and layout items can be
+// expanded into their parts
+func (s *Server) variable(name string, value backend.Data) variable {
+	v := variable{Name: name, Value: backend.Format(value)}
+
+	switch value := value.(type) {
+	case backend.IntData:
+		v.Type = "int"
+	case backend.StringData:
+		v.Type, v.Value = "string", fmt.Sprintf("%q", value.Value)
+	case backend.BooleanData:
+		v.Type = "bool"
+	case backend.ListData:
+		v.Type = "list"
+		v.VariablesReference = s.reference(func() []variable {
+			var variables []variable
+			for i, item := range value.Values {
+				variables = append(variables, s.variable(fmt.Sprint(i), item))
+			}
+			return variables
+		})
+	case backend.MapData:
+		v.Type = "map"
+		v.VariablesReference = s.reference(func() []variable {
+			var variables []variable
+			for _, key := range value.Keys {
+				variables = append(variables, s.variable(fmt.Sprintf("%q", key), value.Values[key]))
+			}
+			return variables
+		})
+	case backend.RecordData:
+		v.Type = value.Type
+		v.VariablesReference = s.reference(func() []variable {
+			var variables []variable
+			for _, field := range value.Fields {
+				variables = append(variables, s.variable(field, value.Values[field]))
+			}
+			return variables
+		})
+	case backend.FunctionData, backend.NativeFunctionData:
+		v.Type = "function"
+	case backend.LayoutItem:
+		v.Type, v.Value = layout(value)
+		v.VariablesReference = s.reference(func() []variable { return s.geometry(value) })
+	}
+
+	return v
+}
+
+// geometry is where the solver put a layout item, and a group's items
+func (s *Server) geometry(item backend.LayoutItem) []variable {
+	number := func(name string, value float64) variable {
+		return variable{Name: name, Value: fmt.Sprintf("%g", value), Type: "float"}
+	}
+
+	variables := []variable{
+		number("x", item.LeftEdge()),
+		number("y", item.Top()),
+		number("w", item.RightEdge()-item.LeftEdge()),
+		number("h", item.Bottom()-item.Top()),
+	}
+	var children []backend.LayoutItem
+	switch item := item.(type) {
+	case backend.Group:
+		children = item.Items
+	case backend.Stack:
+		children = item.Items
+	case backend.Grid:
+		children = item.Items()
+	}
+	for i, child := range children {
+		variables = append(variables, s.variable(fmt.Sprintf("items[%d]", i), child))
+	}
+
+	return variables
+}
+
+// layout is the type and summary of a layout item
+func layout(item backend.LayoutItem) (string, string) {
+	kind, summary := "layout", "layout"
+	switch item := item.(type) {
+	case backend.Box:
+		kind, summary = "box", "box "+item.Id
+	case backend.Group:
+		kind, summary = "group", fmt.Sprintf("group of %d", len(item.Items))
+	case backend.Stack:
+		kind = "vstack"
+		if item.Horizontal {
+			kind = "hstack"
+		}
+		summary = fmt.Sprintf("%s of %d", kind, len(item.Items))
+	case backend.Grid:
+		kind, summary = "grid", fmt.Sprintf("grid of %d", len(item.Cells))
+	}
+
+	return kind, fmt.Sprintf("%s x=%g y=%g w=%g h=%g", summary, item.LeftEdge(), item.Top(),
+		item.RightEdge()-item.LeftEdge(), item.Bottom()-item.Top())
+}
+
+func sortedNames(table map[string]backend.Data) []string {
+	var names []string
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
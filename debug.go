@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const debugHelp = `commands:
+  b <line>     set a breakpoint           clear <line>  remove one
+               (<file>:<line> for a module's lines)
+  c            continue                   n             step over
+  s            step into                  o             step out
+  p <name>     print a variable           vars          print every variable
+  layout       solved boxes and groups    bt            call stack
+  l            show the current line      q             quit`
+
+// debug runs a program under the debugger, reading commands from in
+func debug(fileName string, in io.Reader, out io.Writer) {
+	fileName = absPath(fileName)
+	program := readProgram(fileName)
+	// sources are the lines of each file the program stopped in
+	sources := map[string][]string{fileName: strings.Split(program, "\n")}
+	lines := func(file string) []string {
+		if _, ok := sources[file]; !ok {
+			source, _ := os.ReadFile(file)
+			sources[file] = strings.Split(string(source), "\n")
+		}
+		return sources[file]
+	}
+	commands := bufio.NewScanner(in)
+
+	var debugger *backend.Debugger
+	debugger = backend.NewDebugger(func(stop backend.Stop) backend.StepMode {
+		fmt.Fprintf(out, "stopped (%s) at %s\n", stop.Reason, stop.Position)
+		showLine(out, lines(stop.Position.File), stop.Position.Line)
+
+		for {
+			fmt.Fprint(out, "(debug) ")
+			if !commands.Scan() {
+				fmt.Fprintln(out)
+				os.Exit(0)
+			}
+
+			fields := strings.Fields(commands.Text())
+			if len(fields) == 0 {
+				continue
+			}
+
+			switch fields[0] {
+			case "c", "continue":
+				return backend.Continue
+			case "n", "next":
+				return backend.StepOver
+			case "s", "step":
+				return backend.StepInto
+			case "o", "out":
+				return backend.StepOut
+			case "q", "quit":
+				os.Exit(0)
+			case "b", "break", "clear":
+				if len(fields) < 2 {
+					fmt.Fprintf(out, "usage: %s <line>\n", fields[0])
+					continue
+				}
+				// module paths are relative to the program like imports
+				file, at := fileName, fields[1]
+				if i := strings.LastIndex(at, ":"); i >= 0 {
+					file, at = at[:i], at[i+1:]
+					if !filepath.IsAbs(file) {
+						file = filepath.Join(filepath.Dir(fileName), file)
+					}
+				}
+				line, err := strconv.Atoi(at)
+				if err != nil {
+					fmt.Fprintf(out, "%s isn't a line number\n", at)
+					continue
+				}
+				debugger.SetBreakpoint(file, line, fields[0] != "clear")
+			case "p", "print":
+				if len(fields) < 2 {
+					fmt.Fprintln(out, "usage: p <name>")
+					continue
+				}
+				value, ok := stop.Runtime.SymbolTable[fields[1]]
+				if !ok {
+					fmt.Fprintf(out, "%s isn't set\n", fields[1])
+					continue
+				}
+				fmt.Fprintf(out, "%s = %s\n", fields[1], describe(value))
+			case "vars":
+				for _, name := range sortedNames(stop.Runtime.SymbolTable) {
+					fmt.Fprintf(out, "%s = %s\n", name, backend.Format(stop.Runtime.SymbolTable[name]))
+				}
+			case "layout":
+				for _, name := range sortedNames(stop.Runtime.SymbolTable) {
+					if item, ok := stop.Runtime.SymbolTable[name].(backend.LayoutItem); ok {
+						fmt.Fprintf(out, "%s = %s\n", name, describe(item))
+					}
+				}
+			case "bt", "stack":
+				for _, frame := range stop.Stack {
+					fmt.Fprintf(out, "  in %s\n", frame)
+				}
+				fmt.Fprintln(out, "  in <program>")
+			case "l", "list":
+				showLine(out, lines(stop.Position.File), stop.Position.Line)
+			default:
+				fmt.Fprintln(out, debugHelp)
+			}
+		}
+	})
+	debugger.StopOnEntry = true
+
+	rt := newRuntime(fileName)
+	rt.Hook = debugger
+
+	if err := exec.RunWith(rt, program); err != nil {
+		fmt.Fprintln(out, "runtime error:", err)
+		if runtimeErr, ok := err.(backend.RuntimeError); ok {
+			fmt.Fprint(out, runtimeErr.StackTrace())
+		}
+		os.Exit(1)
+	}
+	fmt.Fprintln(out, "program finished")
+}
+
+func showLine(out io.Writer, lines []string, line int) {
+	if line > 0 && line <= len(lines) {
+		fmt.Fprintf(out, "%4d | %s\n", line, lines[line-1])
+	}
+}
+
+// describe formats a value, layout items show where the solver put them
+func describe(value backend.Data) string {
+	item, ok := value.(backend.LayoutItem)
+	if !ok {
+		return backend.Format(value)
+	}
+
+	kind := "layout"
+	switch item := item.(type) {
+	case backend.Box:
+		kind = "box " + item.Id
+	case backend.Group:
+		kind = fmt.Sprintf("group of %d", len(item.Items))
+	case backend.Stack:
+		kind = fmt.Sprintf("%s of %d", stackKind(item), len(item.Items))
+	case backend.Grid:
+		kind = fmt.Sprintf("grid of %d", len(item.Cells))
+	}
+
+	return fmt.Sprintf("%s x=%.2f y=%.2f w=%.2f h=%.2f", kind, item.LeftEdge(), item.Top(),
+		item.RightEdge()-item.LeftEdge(), item.Bottom()-item.Top())
+}
+
+func sortedNames(table map[string]backend.Data) []string {
+	var names []string
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func stackKind(stack backend.Stack) string {
+	if stack.Horizontal {
+		return "hstack"
+	}
+	return "vstack"
+}
@@ -1,20 +1,94 @@
 package execute
 
 import (
+	"context"
 	"github.com/adam-bunce/morpheus/backend"
 	parser "github.com/adam-bunce/morpheus/generated"
 	"github.com/antlr4-go/antlr/v4"
 )
 
-func RunProgram(source string) backend.Runtime {
+func parse(source string) backend.Block {
 	cs := antlr.NewInputStream(source)
 	lexer := parser.NewmorpheusLexer(cs)
 	tokens := antlr.NewCommonTokenStream(lexer, 0)
 	p := parser.NewmorpheusParser(tokens)
+
+	return p.Program().GetStatements()
+}
+
+func RunProgram(source string) backend.Runtime {
 	rt := backend.NewRuntime()
+	rt.Modules.Parse = parse
 
-	result := p.Program()
-	result.GetStatements().Eval(rt)
+	parse(source).Eval(rt)
 
 	return rt
 }
+
+// Run is RunProgram but returns runtime errors raised by the program
+// instead of panicking
+func Run(source string) (backend.Runtime, error) {
+	rt := backend.NewRuntime()
+
+	return rt, RunWith(rt, source)
+}
+
+// RunWith runs source in an existing runtime, so embedders can register
+// native functions, seed variables or set the module search path first
+func RunWith(rt backend.Runtime, source string) error {
+	return guard(rt, func() { parse(source).Eval(rt) })
+}
+
+// RunCompiled is RunWith on the bytecode vm instead of the tree walking
+// interpreter, programs behave the same but run faster
+func RunCompiled(rt backend.Runtime, source string) error {
+	return guard(rt, func() { rt.RunCompiled(backend.Compile(parse(source))) })
+}
+
+// guard runs a program, returning the runtime error it raised
+func guard(rt backend.Runtime, run func()) (err error) {
+	if rt.Modules != nil && rt.Modules.Parse == nil {
+		rt.Modules.Parse = parse
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			runtimeErr, ok := r.(backend.RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = runtimeErr
+		}
+	}()
+
+	run()
+
+	return nil
+}
+
+// RunContext is RunWith with cancellation and execution limits, hitting one
+// returns a backend.RuntimeError wrapping the matching backend.Err* value
+// (check with errors.Is)
+func RunContext(ctx context.Context, rt backend.Runtime, source string, limits backend.Limits) error {
+	limited, cancel := rt.WithLimits(ctx, limits)
+	defer cancel()
+
+	return RunWith(limited, source)
+}
+
+// RunTests runs source, then each `test` block it defined, an error means
+// the program itself failed before the tests could run
+func RunTests(rt backend.Runtime, source string) ([]backend.TestResult, error) {
+	rt = rt.CollectTests()
+	program := parse(source)
+	if err := guard(rt, func() { program.Eval(rt) }); err != nil {
+		return nil, err
+	}
+
+	return rt.RunTests(program), nil
+}
+
+// CheckProgram type checks source without running it
+func CheckProgram(source string) []backend.TypeError {
+	return backend.Check(parse(source))
+}
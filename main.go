@@ -1,19 +1,70 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/adam-bunce/morpheus/backend"
+	"github.com/adam-bunce/morpheus/dap"
 	exec "github.com/adam-bunce/morpheus/execute"
 	"io"
 	"os"
+	"path/filepath"
 )
 
+const usage = `usage: ./morpheus [check|test|debug|--vm] <program.mph>
+       ./morpheus run [--vm] [--profile[=out.pb.gz]] <program.mph>
+       ./morpheus render [--explain] <program.mph>
+       ./morpheus dap`
+
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("usage: ./morpheus <program.mph>")
+		fmt.Println(usage)
 		os.Exit(1)
 	}
-	fileName := os.Args[1]
 
+	switch os.Args[1] {
+	case "check":
+		if len(os.Args) < 3 {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		check(readProgram(os.Args[2]))
+	case "test":
+		if len(os.Args) < 3 {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		test(os.Args[2])
+	case "debug":
+		if len(os.Args) < 3 {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		debug(os.Args[2], os.Stdin, os.Stdout)
+	case "run":
+		runCommand(os.Args[2:])
+	case "render":
+		renderCommand(os.Args[2:])
+	case "dap":
+		server := dap.NewServer(os.Stdin, os.Stdout)
+		server.NewRuntime = newRuntime
+		if err := server.Serve(); err != nil {
+			fmt.Fprintln(os.Stderr, "dap:", err)
+			os.Exit(1)
+		}
+	case "--vm":
+		if len(os.Args) < 3 {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		run(os.Args[2], exec.RunCompiled)
+	default:
+		run(os.Args[1], exec.RunWith)
+	}
+}
+
+func readProgram(fileName string) string {
 	file, err := os.Open(fileName)
 	if err != nil {
 		fmt.Printf("couldn't find %s...", fileName)
@@ -27,5 +78,180 @@ func main() {
 		os.Exit(1)
 	}
 
-	exec.RunProgram(string(program))
+	return string(program)
+}
+
+func newRuntime(fileName string) backend.Runtime {
+	rt := backend.NewRuntime()
+	rt.Dir, rt.File = filepath.Dir(fileName), absPath(fileName)
+	rt.Modules.SearchPath = filepath.SplitList(os.Getenv("MORPHEUS_PATH"))
+
+	return rt
+}
+
+// absPath is path made absolute, the module loader keys files by absolute
+// path so positions and breakpoints have to use them too
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func run(fileName string, runner func(backend.Runtime, string) error) {
+	exitOnError(runner(newRuntime(fileName), readProgram(fileName)))
+}
+
+// exitOnError prints a program's runtime error with its stack trace and exits
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Println("runtime error:", err)
+		var runtimeErr backend.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			fmt.Print(runtimeErr.StackTrace())
+		}
+		os.Exit(1)
+	}
+}
+
+func check(program string) {
+	errs := exec.CheckProgram(program)
+	for _, err := range errs {
+		fmt.Println("type error:", err)
+	}
+
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("ok")
+}
+
+func test(fileName string) {
+	results, err := exec.RunTests(newRuntime(fileName), readProgram(fileName))
+	if err != nil {
+		fmt.Println("runtime error:", err)
+		os.Exit(1)
+	}
+
+	var failed int
+	for _, result := range results {
+		if result.Passed() {
+			fmt.Printf("PASS %s\n", result.Name)
+		} else {
+			failed++
+			fmt.Printf("FAIL %s: %s\n", result.Name, result.Err)
+			var runtimeErr backend.RuntimeError
+			if errors.As(result.Err, &runtimeErr) {
+				fmt.Print(runtimeErr.StackTrace())
+			}
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// profileFlag is --profile for a text report on stderr or --profile=file to
+// write a pprof profile
+type profileFlag struct {
+	on   bool
+	file string
+}
+
+func (p *profileFlag) String() string   { return p.file }
+func (p *profileFlag) IsBoolFlag() bool { return true }
+
+func (p *profileFlag) Set(value string) error {
+	switch value {
+	case "true":
+		p.on, p.file = true, ""
+	case "false":
+		p.on, p.file = false, ""
+	default:
+		p.on, p.file = true, value
+	}
+	return nil
+}
+
+func runCommand(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	vm := flags.Bool("vm", false, "run on the bytecode vm")
+	var profile profileFlag
+	flags.Var(&profile, "profile", "print where the program spent its time, or write a pprof profile to the given file")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	fileName := flags.Arg(0)
+
+	runner := exec.RunWith
+	if *vm {
+		runner = exec.RunCompiled
+	}
+
+	if !profile.on {
+		run(fileName, runner)
+		return
+	}
+
+	if *vm {
+		fmt.Fprintln(os.Stderr, "warning: the vm only profiles function calls, run without --vm for time per line")
+	}
+	rt := newRuntime(fileName)
+	profiler := backend.NewProfiler()
+	rt.Hook = profiler
+	err := runner(rt, readProgram(fileName))
+
+	// the profile is written even if the program failed
+	if profile.file == "" {
+		profiler.Report(os.Stderr)
+	} else if err := writeProfile(profiler, profile.file, fileName); err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't write profile:", err)
+	}
+	exitOnError(err)
+}
+
+func writeProfile(profiler *backend.Profiler, out, fileName string) error {
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return profiler.WritePprof(file, fileName)
+}
+
+// renderCommand runs a program then shows where each box and group it left
+// in a variable ended up, with --explain the constraints that put it there
+func renderCommand(args []string) {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	explain := flags.Bool("explain", false, "list the active constraints behind each position")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	fileName := flags.Arg(0)
+
+	rt := newRuntime(fileName)
+	exitOnError(exec.RunWith(rt, readProgram(fileName)))
+
+	for _, name := range sortedNames(rt.SymbolTable) {
+		item, ok := rt.SymbolTable[name].(backend.LayoutItem)
+		if !ok {
+			continue
+		}
+
+		fmt.Printf("%s = %s\n", name, describe(item))
+		if *explain {
+			for _, constraint := range rt.Constraints.Explain(item) {
+				fmt.Printf("  %s\n", constraint)
+			}
+		}
+	}
 }
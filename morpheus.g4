@@ -8,9 +8,12 @@ import neo;
 
 program returns [backend.Block statements]
     :
-      { var listOfExpressions []backend.Expression }
-      (statement { listOfExpressions = append(listOfExpressions, $statement.expression); })*
-      { $statements = backend.Block{Exprs: listOfExpressions}; }
+      { var listOfExpressions []backend.Expression; var positions []backend.Position }
+      (statement {
+          listOfExpressions = append(listOfExpressions, $statement.expression);
+          positions = append(positions, backend.Position{Line: $statement.start.GetLine(), Column: $statement.start.GetColumn() + 1});
+      })*
+      { $statements = backend.Block{Exprs: listOfExpressions, Positions: positions}; }
     ;
 
 
@@ -20,38 +23,69 @@ statement returns [backend.Expression expression]
     | loop { $expression = $loop.expression }
     | funDef { $expression = $funDef.expression }
     | ifElse { $expression = $ifElse.expression}
+    | recordDef { $expression = $recordDef.expression }
+    | importStmt SEMICOLON? { $expression = $importStmt.expression }
+    | testBlock { $expression = $testBlock.expression }
     | builtIn SEMICOLON? { $expression = $builtIn.expression }
     ;
 
-assignment returns [backend.Assign expression]
+assignment returns [backend.Expression expression]
     : 'let'? ID ASSIGN expr { $expression = backend.Assign{Name: $ID.text, Expr: $expr.expression} } // bind expr to ID
+    | e1=expr LSQBRACE e2=expr RSQBRACE ASSIGN e3=expr
+        { $expression = backend.IndexAssign{Target: $e1.expression, Position: $e2.expression, Value: $e3.expression} } // list[0] = 1
+    | e1=expr DOT ID ASSIGN e2=expr
+        { $expression = backend.FieldAssign{Receiver: $e1.expression, Field: $ID.text, Value: $e2.expression} } // card.width = 1
     ;
 
 expr returns [backend.Expression expression]
     : LPAREN expr RPAREN { $expression = $expr.expression }
-    | e1=expr PLUS e2=expr { $expression = backend.Arithmetic{Left: $e1.expression, Right: $e2.expression, Op: backend.ADD} }
-    | e1=expr SUBTRACT e2=expr { $expression = backend.Arithmetic{Left: $e1.expression, Right: $e2.expression, Op: backend.SUB} }
-    | e1=expr ASTERISK e2=expr { $expression = backend.Arithmetic{Left: $e1.expression, Right: $e2.expression, Op: backend.MUL} }
-    | e1=expr SLASH e2=expr { $expression = backend.Arithmetic{Left: $e1.expression, Right: $e2.expression, Op: backend.DIV} }
+    // alternatives are listed from highest to lowest precedence
+    | e1=expr DOT ID LPAREN al=argList RPAREN
+        { $expression = backend.MethodCall{Receiver: $e1.expression, Name: $ID.text, Args: $al.expressionList} } // [1,2].add(3).len
+    | e1=expr DOT ID { $expression = backend.FieldAccess{Receiver: $e1.expression, Field: $ID.text} } // record field or .len/.keys
+    | e1=expr LSQBRACE e2=expr RSQBRACE { $expression = backend.Index{Target: $e1.expression, Position: $e2.expression} } // list[-1]
+    | e1=expr LSQBRACE sliceRange RSQBRACE
+        { $expression = backend.Slice{Target: $e1.expression, Start: $sliceRange.low, Stop: $sliceRange.high} } // list[1:3]
+    | <assoc=right> e1=expr POW e2=expr { $expression = backend.Arithmetic{Left: $e1.expression, Right: $e2.expression, Op: backend.POW} }
+    | SUBTRACT e1=expr { $expression = backend.Negate{Expr: $e1.expression} }
+    | e1=expr op=(ASTERISK | SLASH | PERCENT) e2=expr
+        { $expression = backend.Arithmetic{Left: $e1.expression, Right: $e2.expression, Op: backend.ArithOpFromStr($op.text)} }
+    | e1=expr op=(PLUS | SUBTRACT) e2=expr
+        { $expression = backend.Arithmetic{Left: $e1.expression, Right: $e2.expression, Op: backend.ArithOpFromStr($op.text)} }
     | e1=expr PLUS PLUS e2=expr{ $expression = backend.Concat{Left: $e1.expression, Right: $e2.expression} } // str concat
-    | ID LPAREN al=argList RPAREN { $expression = backend.FunctionCall{Name: $ID.text, Args: $al.expressionList} } // func call
-    | LPAREN compare RPAREN { $expression = $compare.expression } // evals to boolean
+    | e1=expr op=(LT | GT | LTE | GTE | EQ | NEQ) e2=expr
+        { $expression = backend.Compare{Left: $e1.expression, Right: $e2.expression, Op: backend.CmpOpFromStr($op.text)} } // evals to boolean
+    | NOT e1=expr { $expression = backend.Not{Expr: $e1.expression} }
+    | e1=expr 'and' e2=expr { $expression = backend.Compare{Left: $e1.expression, Right: $e2.expression, Op: backend.AND} }
+    | e1=expr 'or' e2=expr { $expression = backend.Compare{Left: $e1.expression, Right: $e2.expression, Op: backend.OR} }
+    | ID LPAREN al=argList RPAREN { $expression = backend.FunctionCall{Name: $ID.text, Args: $al.expressionList, Line: $ID.line, Column: $ID.pos + 1} } // func call
     | ID { $expression = backend.Dereference{ Name: $ID.text } } // derefrence var
     | list { $expression = $list.expression }
-    | listOp { $expression = $listOp.expression }
+    | mapLiteral { $expression = $mapLiteral.expression }
     | NUMBER { $expression = backend.NewIntLiteral($NUMBER.text) }
     | STRING { $expression = backend.NewStringLiteral($STRING.text) }
     | BOOLEAN { $expression = backend.NewBooleanLiteral($BOOLEAN.text) }
 
-    | 'Box' LPAREN STRING RPAREN{ $expression = backend.BoxExpr{Id: $STRING.text} }
+    | 'Box' LPAREN STRING RPAREN{ $expression = backend.BoxExpr{Id: $STRING.text, Line: $start.GetLine()} }
     | 'Group' LPAREN list COLON LSQBRACE cl=constraintList RSQBRACE RPAREN
-         { $expression = backend.GroupExpr{Items: $list.expression, Constraints: $cl.ret} }
+         { $expression = backend.GroupExpr{Items: $list.expression, Constraints: $cl.ret, Line: $start.GetLine()} }
+    | k=('HStack' | 'VStack') LPAREN items=expr
+         { stack := backend.StackExpr{Horizontal: $k.text == "HStack", Items: $items.expression, Line: $start.GetLine()} }
+         (COMMA sp=expr { stack.Spacing = $sp.expression } (COMMA al=expr { stack.Alignment = $al.expression })?)? RPAREN
+         { $expression = stack }
+    | 'Grid' LPAREN items=expr COMMA cols=expr
+         { grid := backend.GridExpr{Items: $items.expression, Columns: $cols.expression, Line: $start.GetLine()} }
+         (COMMA gap=expr { grid.Gap = $gap.expression })? RPAREN
+         { $expression = grid }
     ;
 
 block returns [backend.Block expression]
-    :  {var blockExprs []backend.Expression}
-       ( statement { blockExprs = append(blockExprs, $statement.expression); } )*
-       { $expression = backend.Block{Exprs: blockExprs}; }
+    :  {var blockExprs []backend.Expression; var positions []backend.Position}
+       ( statement {
+           blockExprs = append(blockExprs, $statement.expression);
+           positions = append(positions, backend.Position{Line: $statement.start.GetLine(), Column: $statement.start.GetColumn() + 1});
+       } )*
+       { $expression = backend.Block{Exprs: blockExprs, Positions: positions}; }
     ;
 
 list returns [backend.Expression expression]
@@ -60,17 +94,17 @@ list returns [backend.Expression expression]
       { $expression = backend.List{Values: exprList} }
     ;
 
-listOrId returns [backend.Expression expression]
-    : list { $expression = $list.expression }
-    | ID { $expression = backend.Dereference{Name: $ID.text } }
+// either bound can be left out, list[:2] list[1:]
+sliceRange returns [backend.Expression low, backend.Expression high]
+    : (lo=expr { $low = $lo.expression })? COLON (hi=expr { $high = $hi.expression })?
     ;
 
-// NOTE: you can't chain these together, so no [1,2,3].del(3).add(3).len
-listOp returns [backend.Expression expression]
-    : listOrId '.get' LPAREN e1=expr RPAREN{ $expression = backend.ListIndex{List: $listOrId.expression, Position: $e1.expression} }
-    | listOrId '.add' LPAREN e1=expr RPAREN{ $expression = backend.ListAdd{List: $listOrId.expression, Value: $e1.expression} }
-    | listOrId '.del' LPAREN e1=expr RPAREN{ $expression = backend.ListDelete{List: $listOrId.expression, Position: $e1.expression} }
-    | listOrId '.len'  { $expression = backend.ListLength{List: $listOrId.expression} }
+mapLiteral returns [backend.Expression expression]
+    : { var keys []backend.Expression }
+      { var values []backend.Expression }
+      LBRACE (k1=expr COLON v1=expr { keys = append(keys, $k1.expression); values = append(values, $v1.expression) }
+        (COMMA k2=expr COLON v2=expr { keys = append(keys, $k2.expression); values = append(values, $v2.expression) })*)? RBRACE
+      { $expression = backend.Map{Keys: keys, Values: values} }
     ;
 
 loop returns [backend.Expression expression]
@@ -82,6 +116,12 @@ loop returns [backend.Expression expression]
                                     Stop:     $e2.expression,
                                     Step:     $e3.expression,
                                     Body:     $body.expression } }
+    | 'for' ID 'in' e1=expr LBRACE
+        body=block
+      RBRACE
+      { $expression = backend.ForEach{ Iterator: $ID.text,
+                                       Iterable: $e1.expression,
+                                       Body:     $body.expression } }
     ;
 
 
@@ -89,15 +129,29 @@ funDef returns [backend.Expression expression]
     : 'function' ID LPAREN paramList RPAREN LBRACE
         block
       RBRACE
-      { $expression = backend.Declare{Name: $ID.text, Args: $paramList.params, Body: $block.expression} }
+      { $expression = backend.Declare{Name: $ID.text, Args: $paramList.params, ArgTypes: $paramList.types, Body: $block.expression} }
     ;
 
-paramList returns [[]string params]
+paramList returns [[]string params, []string types]
     :
     { var parameterList []string }
-    (id1=ID { parameterList = append(parameterList, $id1.text)}
-      (COMMA idn=ID {parameterList = append(parameterList, $idn.text)} )*)?
+    { var typeList []string }
+    (p1=param { parameterList = append(parameterList, $p1.name); typeList = append(typeList, $p1.typeName) }
+      (COMMA pn=param { parameterList = append(parameterList, $pn.name); typeList = append(typeList, $pn.typeName) } )*)?
     { $params = parameterList}
+    { $types = typeList }
+    ;
+
+// fields are declared like parameters, Card("title", 100) constructs one
+recordDef returns [backend.Expression expression]
+    : 'record' ID LBRACE paramList RBRACE
+      { $expression = backend.RecordDecl{Name: $ID.text, Fields: $paramList.params, FieldTypes: $paramList.types} }
+    ;
+
+// optional type annotation, checked by `morpheus check` e.g. function add(x: int, y: int)
+param returns [string name, string typeName]
+    : ID { $name = $ID.text }
+      (COLON t=ID { $typeName = $t.text })?
     ;
 
 argList returns [[]backend.Expression expressionList]
@@ -109,23 +163,15 @@ argList returns [[]backend.Expression expressionList]
     ;
 
 
-compare returns [backend.Expression expression]
-    : e1=expr LT e2=expr { $expression = backend.Compare{Left: $e1.expression, Right: $e2.expression, Op: backend.LT} }
-    | e1=expr GT e2=expr { $expression = backend.Compare{Left: $e1.expression, Right: $e2.expression, Op: backend.GT} }
-    | e1=expr ASSIGN ASSIGN e2=expr { $expression = backend.Compare{Left: $e1.expression, Right: $e2.expression, Op: backend.EQ} }
-    | e1=expr 'and' e2=expr { $expression = backend.Compare{Left: $e1.expression, Right: $e2.expression, Op: backend.AND} }
-    | e1=expr 'or' e2=expr { $expression = backend.Compare{Left: $e1.expression, Right: $e2.expression, Op: backend.OR} }
-    ;
-
 ifElse returns [backend.Expression expression]
     :
          { var elifConds []backend.Conditional }
          { var elseExpr backend.Expression }
-        'if' LPAREN ifComparison=compare RPAREN LBRACE
+        'if' LPAREN ifComparison=expr RPAREN LBRACE
             ifBlock=block
         RBRACE
 
-        ( 'elif' LPAREN elifComparison=compare RPAREN LBRACE
+        ( 'elif' LPAREN elifComparison=expr RPAREN LBRACE
             elifBlock=block
         RBRACE {
          elifConds = append(elifConds, backend.Conditional{Condition: $elifComparison.expression, Body: $elifBlock.expression})
@@ -149,8 +195,17 @@ ifElse returns [backend.Expression expression]
 
     ;
 
+importStmt returns [backend.Expression expression]
+    : 'import' STRING 'as' ID { $expression = backend.NewImport($STRING.text, $ID.text) } // import "cards.mph" as cards
+    ;
+
+// only run by `morpheus test`
+testBlock returns [backend.Expression expression]
+    : 'test' STRING LBRACE body=block RBRACE { $expression = backend.NewTestBlock($STRING.text, $body.expression) }
+    ;
+
 builtIn returns [backend.Expression expression]
-    : 'print' LPAREN expr RPAREN { $expression = backend.Print{ToPrint: $expr.expression} }
+    : 'print' LPAREN al=argList RPAREN { $expression = backend.Print{Args: $al.expressionList} } // print("w", 10)
     | expr '.htmlify'LPAREN STRING RPAREN { $expression = backend.Htmlify{Layout: $expr.expression, File: $STRING.text}}
     ;
 
@@ -168,7 +223,10 @@ SLASH: '/' ;
 PLUS: '+' ;
 SUBTRACT: '-' ;
 ASTERISK: '*' ;
+POW: '**' ;
+PERCENT: '%' ;
 COMMA: ',' ;
+DOT: '.' ;
 ASSIGN: '=' ;
 LBRACE: '{' ;
 RBRACE: '}' ;
@@ -176,8 +234,13 @@ LSQBRACE: '[' ;
 RSQBRACE: ']' ;
 LT: '<' ;
 GT: '>' ;
+LTE: '<=' ;
+GTE: '>=' ;
+EQ: '==' ;
+NEQ: '!=' ;
+NOT: 'not' | '!' ;
 
-NUMBER: '-'?DIGIT+('.'DIGIT+)? ;
+NUMBER: DIGIT+('.'DIGIT+)? ; // negative numbers are unary minus
 STRING: '"' ~('"')+ '"' ;
 BOOLEAN: 'true' | 'false' ;
 
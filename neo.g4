@@ -11,10 +11,10 @@ constraintList returns [[]backend.Constraint ret]:
 ;
 
 constraint returns [backend.Constraint ret]
-    : li=ITEM 'is left of' ri=ITEM { $ret = backend.Constraint{LeftItemName: $li.text, RightItemName: $ri.text, ConstraintType: backend.Left}}
-    | li=ITEM 'is right of' ri=ITEM { $ret = backend.Constraint{LeftItemName: $li.text, RightItemName: $ri.text, ConstraintType: backend.Right}}
-    | li=ITEM 'is below' ri=ITEM { $ret = backend.Constraint{LeftItemName: $li.text, RightItemName: $ri.text, ConstraintType: backend.Below}}
-    | li=ITEM 'is above' ri=ITEM{ $ret = backend.Constraint{LeftItemName: $li.text, RightItemName: $ri.text, ConstraintType: backend.Above}}
+    : li=ITEM 'is left of' ri=ITEM { $ret = backend.Constraint{LeftItemName: $li.text, RightItemName: $ri.text, ConstraintType: backend.Left, Line: $li.line}}
+    | li=ITEM 'is right of' ri=ITEM { $ret = backend.Constraint{LeftItemName: $li.text, RightItemName: $ri.text, ConstraintType: backend.Right, Line: $li.line}}
+    | li=ITEM 'is below' ri=ITEM { $ret = backend.Constraint{LeftItemName: $li.text, RightItemName: $ri.text, ConstraintType: backend.Below, Line: $li.line}}
+    | li=ITEM 'is above' ri=ITEM{ $ret = backend.Constraint{LeftItemName: $li.text, RightItemName: $ri.text, ConstraintType: backend.Above, Line: $li.line}}
     ;
 
 fragment LETTER: 'a'..'z' | 'A'..'Z' ;
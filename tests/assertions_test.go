@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"errors"
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"strings"
+	"testing"
+)
+
+func TestTestBlocks(t *testing.T) {
+	program := `
+		function double(n) { n * 2 }
+		x = 1;
+
+		test "double" {
+			assert_eq(double(2), 4);
+			assert(double(0) == 0, "zero");
+		}
+
+		test "tests get their own variables" {
+			x = 5;
+			assert_eq(x, 5);
+		}
+
+		test "x is unchanged" {
+			assert_eq(x, 1);
+		}
+
+		test "fails" {
+			assert(double(1) == 3, "double(1) should be 3");
+		}
+
+		test "layout" {
+			a = Box("a");
+			b = Box("b");
+			g = Group([a, b] : [*a is left of *b, *b is below *a]);
+			assert_left_of(a, b);
+			assert_below(b, a);
+			assert_right_of(a, b);
+		}
+	`
+
+	expected := []struct {
+		name   string
+		passed bool
+	}{
+		{"double", true},
+		{"tests get their own variables", true},
+		{"x is unchanged", true},
+		{"fails", false},
+		{"layout", false},
+	}
+
+	rt := backend.NewRuntime()
+	results, err := exec.RunTests(rt, program)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results got %d", len(expected), len(results))
+	}
+
+	for i, result := range results {
+		if result.Name != expected[i].name || result.Passed() != expected[i].passed {
+			t.Fatalf("[test %d] expected %s passed=%t got %s passed=%t (%v)",
+				i+1, expected[i].name, expected[i].passed, result.Name, result.Passed(), result.Err)
+		}
+		if !result.Passed() && !errors.Is(result.Err, backend.ErrAssertion) {
+			t.Fatalf("[test %d] expected an assertion error got %v", i+1, result.Err)
+		}
+	}
+
+	// test blocks don't run with the rest of the program
+	if _, err := exec.Run(`test "never runs" { assert(false); }`); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// asserts outside tests are just runtime errors
+	if _, err := exec.Run(`assert_eq(1, 2);`); !errors.Is(err, backend.ErrAssertion) {
+		t.Fatalf("expected an assertion error got %v", err)
+	}
+}
+
+func TestTestBlocksLayoutIsolation(t *testing.T) {
+	// constraints a test adds to the program's boxes stay in that test
+	program := `
+		a = Box("a");
+		b = Box("b");
+		g = Group([a, b] : [*a is left of *b]);
+		print("setup");
+
+		test "a below b" {
+			below = Group([a, b] : [*a is below *b]);
+			assert_below(a, b);
+		}
+
+		test "a above b" {
+			above = Group([a, b] : [*a is above *b]);
+			assert_above(a, b);
+			assert_left_of(a, b);
+		}
+	`
+
+	rt := backend.NewRuntime()
+	var stdout strings.Builder
+	rt.Stdout = &stdout
+	results, err := exec.RunTests(rt, program)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for i, result := range results {
+		if !result.Passed() {
+			t.Fatalf("[test %d] %s failed: %v", i+1, result.Name, result.Err)
+		}
+	}
+
+	// the program reruns for each test without printing again
+	if stdout.String() != "setup\n" {
+		t.Fatalf("expected the program to print once got %q", stdout.String())
+	}
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"errors"
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"strings"
+	"testing"
+)
+
+var runners = map[string]func(backend.Runtime, string) error{
+	"interpreted": exec.RunWith,
+	"compiled":    exec.RunCompiled,
+}
+
+func TestTailCalls(t *testing.T) {
+	num := func(i int) backend.Data { return backend.IntData{Value: i} }
+
+	tests := []struct {
+		program  string
+		variable string
+		expected backend.Data
+	}{
+		{`function sum(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } } x = sum(100000, 0);`, "x", num(5000050000)},
+		{`function even(n) { if (n == 0) { true } else { odd(n - 1) } }
+		  function odd(n) { if (n == 0) { false } else { even(n - 1) } }
+		  x = even(50001);`, "x", backend.BooleanData{Value: false}},
+		// a tail call from a function without arguments still writes into its callers scope
+		{`function set() { y = 5; } function run() { set() } run(); x = y;`, "x", num(5)},
+		{`function inner(n) { n + outer } function f(outer) { inner(1) } x = f(10);`, "x", num(11)},
+	}
+
+	for name, run := range runners {
+		for i, test := range tests {
+			rt := backend.NewRuntime()
+			rt.MaxCallDepth = 100
+
+			if err := run(rt, test.program); err != nil {
+				t.Fatalf("[%s test %d] unexpected error %v", name, i+1, err)
+			}
+			if !backend.Equal(rt.SymbolTable[test.variable], test.expected) {
+				t.Fatalf("[%s test %d] expected %s, got %s", name, i+1, test.expected, rt.SymbolTable[test.variable])
+			}
+		}
+	}
+}
+
+func TestCallDepth(t *testing.T) {
+	program := `function count(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } } function start(n) { 0 + count(n) } start(1000000);`
+
+	for name, run := range runners {
+		err := run(backend.NewRuntime(), program)
+		if !errors.Is(err, backend.ErrCallDepth) {
+			t.Fatalf("[%s] expected %v, got %v", name, backend.ErrCallDepth, err)
+		}
+
+		var runtimeErr backend.RuntimeError
+		errors.As(err, &runtimeErr)
+		if len(runtimeErr.Stack) != backend.DefaultMaxCallDepth+1 || runtimeErr.Stack[len(runtimeErr.Stack)-1].Function != "start" {
+			t.Fatalf("[%s] unexpected stack of %d frames", name, len(runtimeErr.Stack))
+		}
+		expected := "call stack, most recent call first:\n" +
+			"  in count(990001) at line 1:50 (9999 times)\n" +
+			"  in count(1000000) at line 1:91\n" +
+			"  in start(1000000) at line 1:102\n"
+		if trace := runtimeErr.StackTrace(); trace != expected {
+			t.Fatalf("[%s] expected trace %q, got %q", name, expected, trace)
+		}
+
+		// the limit is configurable
+		rt := backend.NewRuntime()
+		rt.MaxCallDepth = 10
+		err = run(rt, strings.Replace(program, "1000000", "5", 1))
+		if err != nil {
+			t.Fatalf("[%s] unexpected error %v", name, err)
+		}
+		err = run(rt, strings.Replace(program, "1000000", "20", 1))
+		if !errors.Is(err, backend.ErrCallDepth) {
+			t.Fatalf("[%s] expected %v, got %v", name, backend.ErrCallDepth, err)
+		}
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	program := `function inner(s, n) {
+    substr(s, n, 99)
+}
+function outer(xs) {
+    1 + inner("hello", xs.len)
+}
+outer([1, "a"]);`
+
+	expected := []backend.StackFrame{
+		{Function: "substr", Line: 2, Column: 5, Args: []backend.Data{backend.StringData{Value: "hello"}, backend.IntData{Value: 2}, backend.IntData{Value: 99}}},
+		{Function: "inner", Line: 5, Column: 9, Args: []backend.Data{backend.StringData{Value: "hello"}, backend.IntData{Value: 2}}},
+		{Function: "outer", Line: 7, Column: 1, Args: []backend.Data{backend.ListData{Values: []backend.Data{backend.IntData{Value: 1}, backend.StringData{Value: "a"}}}}},
+	}
+
+	for name, run := range runners {
+		err := run(backend.NewRuntime(), program)
+
+		var runtimeErr backend.RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("[%s] expected a RuntimeError, got %v", name, err)
+		}
+		if len(runtimeErr.Stack) != len(expected) {
+			t.Fatalf("[%s] expected %d frames, got %v", name, len(expected), runtimeErr.Stack)
+		}
+		for i, frame := range runtimeErr.Stack {
+			if frame.Function != expected[i].Function || frame.Line != expected[i].Line || frame.Column != expected[i].Column {
+				t.Fatalf("[%s frame %d] expected %s, got %s", name, i, expected[i], frame)
+			}
+			if !backend.Equal(backend.ListData{Values: frame.Args}, backend.ListData{Values: expected[i].Args}) {
+				t.Fatalf("[%s frame %d] expected args %v, got %v", name, i, expected[i].Args, frame.Args)
+			}
+		}
+
+		trace := runtimeErr.StackTrace()
+		if !strings.Contains(trace, `in inner("hello", 2) at line 5:9`) {
+			t.Fatalf("[%s] unexpected trace\n%s", name, trace)
+		}
+	}
+
+	// mistakes in the program itself are runtime errors with a stack too
+	err := exec.RunWith(backend.NewRuntime(), `function f(x) { x } function g() { f() } g();`)
+	var runtimeErr backend.RuntimeError
+	if !errors.As(err, &runtimeErr) || len(runtimeErr.Stack) != 2 || runtimeErr.Stack[0].Function != "f" {
+		t.Fatalf("expected a RuntimeError raised in f, got %v", err)
+	}
+}
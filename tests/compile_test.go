@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestCompiled runs programs on the interpreter and the vm and checks they
+// printed, errored and ended with the same variables
+func TestCompiled(t *testing.T) {
+	programs := []string{
+		// arguments and everything a function assigns stay in the call
+		`i = 7; function f(x) { y = x; for i in (0, 3, 1) { y = y + i; } y } print(f(1), i);`,
+		// functions see their callers variables
+		`outer = 5; function g(q) { q + outer } print(g(1));`,
+		// functions without arguments write into their callers scope
+		`function z() { w = x; } function h(x) { z(); w } print(h(3), h(4));`,
+		`function z() { w = 9; } z(); print(w);`,
+		`xs = [1]; function f(ys) { ys.add(2); ys } print(f(xs), xs); xs.add(5);`,
+		`function fact(n) { if (n < 1) { 1 } else { n * fact(n - 1) } } print(fact(6));`,
+		`print(false and nope, true or nope);`,
+		`print(true and 1);`,
+		// a loop deletes its iterator, hiding the callers variable of the same name
+		`i = 1; function f(x) { for i in (0, 2, 1) { } i } print(f(0));`,
+		`function inc(v) { v + k } function f(k) { r = [1, 2].map(inc); r } print(f(10));`,
+		`print(missing);`,
+		`missing();`,
+		`function f(x) { x } f();`,
+		`m = {"a": 1, "b": 2}; for k in m { print(k, upper(k)); } m.set("c", 3);`,
+		`function f(len) { len } print(f(2), len("abc"));`,
+		`if (1) { }`,
+		`if (false) { } elif (true) { print(1); } elif ("x") { }`,
+		`if (false) { } elif ("x") { print(1); }`,
+		`for j in (5, 0, -2) { print(j); last = j; }`,
+		`for j in (0, "x", 1) { }`,
+		`g = [[1], [2]]; g[1].add(3); print(g, g[0]);`,
+		`record Card { title, width } c = Card("a", 1); c.width = 2; print(c.width, c);`,
+	}
+
+	for i, program := range programs {
+		interpreted := describeRun(t, program, exec.RunWith)
+		compiled := describeRun(t, program, exec.RunCompiled)
+
+		if interpreted != compiled {
+			t.Fatalf("[test %d] %s\ninterpreted: %q\ncompiled:    %q", i+1, program, interpreted, compiled)
+		}
+	}
+}
+
+// describeRun runs program with run and describes what happened, plain panics
+// like uninitialized variables count as output
+func describeRun(t *testing.T, program string, run func(backend.Runtime, string) error) (result string) {
+	var stdout strings.Builder
+	rt := backend.NewRuntime()
+	rt.Stdout = &stdout
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = fmt.Sprintf("%spanic: %v", stdout.String(), r)
+		}
+	}()
+
+	if err := run(rt, program); err != nil {
+		fmt.Fprintf(&stdout, "error: %v\n", err)
+	}
+
+	var names []string
+	for name := range rt.SymbolTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&stdout, "%s = %s\n", name, backend.Format(rt.SymbolTable[name]))
+	}
+
+	return stdout.String()
+}
+
+func TestDisassemble(t *testing.T) {
+	chunk := backend.Compile(backend.Block{Exprs: []backend.Expression{
+		backend.Declare{Name: "f", Args: []string{"x"}, Body: backend.Block{Exprs: []backend.Expression{
+			backend.Assign{Name: "y", Expr: backend.Dereference{Name: "x"}},
+		}}},
+	}})
+
+	disassembly := chunk.String()
+	for _, expected := range []string{"== program ==", "STORE      f", "== f slots x, y ==", "LOAD       x (slot 0)", "STORE      y (slot 1)"} {
+		if !strings.Contains(disassembly, expected) {
+			t.Fatalf("expected %q in\n%s", expected, disassembly)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/adam-bunce/morpheus/dap"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// dapClient talks to a dap.Server the way an editor would
+type dapClient struct {
+	t        *testing.T
+	out      io.Writer
+	messages chan map[string]any
+	seq      int
+	// pending are messages that arrived while waiting for something else
+	pending []map[string]any
+}
+
+func newDapClient(t *testing.T) *dapClient {
+	toServer, clientOut := io.Pipe()
+	clientIn, fromServer := io.Pipe()
+
+	c := &dapClient{t: t, out: clientOut, messages: make(chan map[string]any, 100)}
+	go dap.NewServer(toServer, fromServer).Serve()
+	go func() {
+		in := bufio.NewReader(clientIn)
+		for {
+			header, err := textproto.NewReader(in).ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			length, _ := strconv.Atoi(header.Get("Content-Length"))
+			body := make([]byte, length)
+			if _, err := io.ReadFull(in, body); err != nil {
+				return
+			}
+
+			var msg map[string]any
+			json.Unmarshal(body, &msg)
+			c.messages <- msg
+		}
+	}()
+
+	return c
+}
+
+// request sends a request and returns its response's body, skipping events
+func (c *dapClient) request(command string, args any) map[string]any {
+	c.seq++
+	body, _ := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+
+	msg := c.wait("response", command)
+	for _, early := range c.pending {
+		if early["event"] == "stopped" || early["event"] == "terminated" {
+			c.t.Fatalf("got a %s event before the %s response", early["event"], command)
+		}
+	}
+	if msg["success"] != true {
+		c.t.Fatalf("%s failed: %v", command, msg["message"])
+	}
+	result, _ := msg["body"].(map[string]any)
+	return result
+}
+
+// wait returns the next response or event called name
+func (c *dapClient) wait(kind, name string) map[string]any {
+	matches := func(msg map[string]any) bool {
+		return msg["type"] == kind && (msg["command"] == name || msg["event"] == name)
+	}
+	for i, msg := range c.pending {
+		if matches(msg) {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return msg
+		}
+	}
+
+	for {
+		select {
+		case msg := <-c.messages:
+			if matches(msg) {
+				return msg
+			}
+			c.pending = append(c.pending, msg)
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("timed out waiting for %s %s", kind, name)
+		}
+	}
+}
+
+// variables fetches a reference's variables as name: value
+func (c *dapClient) variables(ref any) (map[string]string, map[string]any) {
+	values, refs := map[string]string{}, map[string]any{}
+	for _, v := range c.request("variables", map[string]any{"variablesReference": ref})["variables"].([]any) {
+		v := v.(map[string]any)
+		values[v["name"].(string)] = v["value"].(string)
+		refs[v["name"].(string)] = v["variablesReference"]
+	}
+	return values, refs
+}
+
+func (c *dapClient) scopes(frameId int) map[string]any {
+	refs := map[string]any{}
+	for _, s := range c.request("scopes", map[string]any{"frameId": frameId})["scopes"].([]any) {
+		s := s.(map[string]any)
+		refs[s["name"].(string)] = s["variablesReference"]
+	}
+	return refs
+}
+
+func TestDapSession(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "debug.mph")
+	if err := os.WriteFile(program, []byte(debugProgram), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newDapClient(t)
+	breakpoints := func(lines ...int) {
+		var bps []any
+		for _, line := range lines {
+			bps = append(bps, map[string]any{"line": line})
+		}
+		c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": program}, "breakpoints": bps})
+	}
+
+	c.request("initialize", map[string]any{"adapterID": "morpheus"})
+	c.wait("event", "initialized")
+	c.request("launch", map[string]any{"program": program})
+	breakpoints(3)
+	c.request("configurationDone", nil)
+
+	stopped := c.wait("event", "stopped")["body"].(map[string]any)
+	if stopped["reason"] != "breakpoint" {
+		t.Fatalf("expected to stop on the breakpoint, got %v", stopped)
+	}
+
+	var frames []string
+	for _, frame := range c.request("stackTrace", map[string]any{"threadId": 1})["stackFrames"].([]any) {
+		frame := frame.(map[string]any)
+		frames = append(frames, fmt.Sprintf("%s %v", frame["name"], frame["line"]))
+	}
+	if fmt.Sprint(frames) != "[f 3 <program> 6]" {
+		t.Fatalf("expected f at line 3 called from line 6, got %v", frames)
+	}
+
+	locals, _ := c.variables(c.scopes(1)["Locals"])
+	if locals["x"] != "1" || locals["y"] != "2" {
+		t.Fatalf("expected x = 1 and y = 2 in f, got %v", locals)
+	}
+	globals, _ := c.variables(c.scopes(2)["Locals"])
+	if globals["a"] != "1" || globals["y"] != "" {
+		t.Fatalf("expected a = 1 and no y in the program, got %v", globals)
+	}
+
+	c.request("next", map[string]any{"threadId": 1})
+	c.wait("event", "stopped")
+	frames = nil
+	for _, frame := range c.request("stackTrace", map[string]any{"threadId": 1})["stackFrames"].([]any) {
+		frames = append(frames, fmt.Sprint(frame.(map[string]any)["line"]))
+	}
+	if fmt.Sprint(frames) != "[7]" {
+		t.Fatalf("expected next to step out of f to line 7, got %v", frames)
+	}
+
+	breakpoints(9)
+	c.request("continue", map[string]any{"threadId": 1})
+	c.wait("event", "stopped")
+
+	layout, refs := c.variables(c.scopes(1)["Layout"])
+	if len(layout) != 1 || layout["bx"] == "" {
+		t.Fatalf("expected just bx in the layout scope, got %v", layout)
+	}
+	geometry, _ := c.variables(refs["bx"])
+	if geometry["w"] != "50" || geometry["h"] != "50" {
+		t.Fatalf("expected bx to be solved 50x50, got %v", geometry)
+	}
+
+	c.request("continue", map[string]any{"threadId": 1})
+	c.wait("event", "terminated")
+	c.request("disconnect", nil)
+}
+
+func TestDapModuleBreakpoints(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.mph":   "import \"shapes.mph\" as shapes;\na = 1;\nb = shapes.twice(a);",
+		"shapes.mph": "function grow(n) {\n    n + 1\n}\nfunction twice(n) { grow(grow(n)) }",
+	})
+
+	c := newDapClient(t)
+	c.request("initialize", map[string]any{"adapterID": "morpheus"})
+	c.request("launch", map[string]any{"program": filepath.Join(dir, "main.mph")})
+	breakpoints := c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": filepath.Join(dir, "shapes.mph")},
+		"breakpoints": []any{map[string]any{"line": 2}},
+	})["breakpoints"].([]any)
+	if breakpoints[0].(map[string]any)["verified"] != true {
+		t.Fatalf("expected the module breakpoint to be verified, got %v", breakpoints)
+	}
+	c.request("configurationDone", nil)
+	c.wait("event", "stopped")
+
+	// each frame's source is the file its line is in
+	var frames []string
+	for _, frame := range c.request("stackTrace", map[string]any{"threadId": 1})["stackFrames"].([]any) {
+		frame := frame.(map[string]any)
+		source := frame["source"].(map[string]any)
+		frames = append(frames, fmt.Sprintf("%s %s:%v", frame["name"], source["name"], frame["line"]))
+	}
+	if fmt.Sprint(frames) != "[grow shapes.mph:2 twice shapes.mph:4 <program> main.mph:0]" {
+		t.Fatalf("expected grow stopped in shapes.mph, got %v", frames)
+	}
+
+	c.request("continue", map[string]any{"threadId": 1})
+	c.wait("event", "stopped")
+	c.request("disconnect", nil)
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const debugProgram = `function f(x) {
+    y = x + 1;
+    y
+}
+a = 1;
+b = f(a);
+c = f(b);
+bx = Box("q");
+print(bx);`
+
+func TestDebuggerStepping(t *testing.T) {
+	tests := []struct {
+		steps    []backend.StepMode
+		expected []string
+	}{
+		{
+			[]backend.StepMode{backend.StepOver, backend.StepOver, backend.StepOver, backend.StepOver},
+			[]string{"entry 1", "step 5", "step 6", "breakpoint 3", "step 7", "breakpoint 3"},
+		},
+		{
+			[]backend.StepMode{backend.StepOver, backend.StepOver, backend.StepInto, backend.StepOver, backend.StepOver},
+			[]string{"entry 1", "step 5", "step 6", "step 2", "step 3", "step 7", "breakpoint 3"},
+		},
+		{
+			// stepping out still stops on breakpoints on the way
+			[]backend.StepMode{backend.StepOver, backend.StepOver, backend.StepInto, backend.StepOut, backend.StepOut},
+			[]string{"entry 1", "step 5", "step 6", "step 2", "breakpoint 3", "step 7", "breakpoint 3"},
+		},
+		{
+			[]backend.StepMode{backend.Continue},
+			[]string{"entry 1", "breakpoint 3", "breakpoint 3"},
+		},
+	}
+
+	for i, test := range tests {
+		var stops []string
+		debugger := backend.NewDebugger(func(stop backend.Stop) backend.StepMode {
+			stops = append(stops, fmt.Sprintf("%s %d", stop.Reason, stop.Position.Line))
+			if len(stops) <= len(test.steps) {
+				return test.steps[len(stops)-1]
+			}
+			return backend.Continue
+		})
+		debugger.StopOnEntry = true
+		debugger.SetBreakpoint("", 3, true)
+
+		rt := backend.NewRuntime()
+		rt.Hook = debugger
+		rt.Stdout = io.Discard
+		if err := exec.RunWith(rt, debugProgram); err != nil {
+			t.Fatalf("[test %d] unexpected error %v", i+1, err)
+		}
+
+		if !reflect.DeepEqual(stops, test.expected) {
+			t.Fatalf("[test %d] expected stops %v, got %v", i+1, test.expected, stops)
+		}
+	}
+}
+
+func TestDebuggerInspect(t *testing.T) {
+	var y, width backend.Data
+	var stack []backend.StackFrame
+	var scopes []backend.Runtime
+
+	debugger := backend.NewDebugger(func(stop backend.Stop) backend.StepMode {
+		switch stop.Position.Line {
+		case 3:
+			if y == nil {
+				y, stack, scopes = stop.Runtime.SymbolTable["y"], stop.Stack, stop.Scopes
+			}
+		case 9:
+			box := stop.Runtime.SymbolTable["bx"].(backend.LayoutItem)
+			width = backend.IntData{Value: int(box.RightEdge() - box.LeftEdge())}
+		}
+		return backend.Continue
+	})
+	debugger.SetBreakpoint("", 3, true)
+	debugger.SetBreakpoint("", 9, true)
+
+	rt := backend.NewRuntime()
+	rt.Hook = debugger
+	rt.Stdout = io.Discard
+	if err := exec.RunWith(rt, debugProgram); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if !backend.Equal(y, backend.IntData{Value: 2}) {
+		t.Fatalf("expected y = 2 at the first stop, got %v", y)
+	}
+	if len(stack) != 1 || stack[0].Function != "f" || stack[0].Line != 6 {
+		t.Fatalf("expected to be in f called from line 6, got %v", stack)
+	}
+	if len(scopes) != 2 || scopes[0].SymbolTable["x"] == nil || scopes[1].SymbolTable["x"] != nil {
+		t.Fatalf("expected f's scope with x then the program's without, got %v", scopes)
+	}
+	if !backend.Equal(width, backend.IntData{Value: 50}) {
+		t.Fatalf("expected the box to be solved 50 wide, got %v", width)
+	}
+}
+
+func TestDebuggerModuleBreakpoints(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"shapes.mph": "function grow(n) {\n    n + 1\n}\nfunction twice(n) { grow(grow(n)) }",
+	})
+	module := filepath.Join(dir, "shapes.mph")
+
+	var stops []string
+	var stack []backend.StackFrame
+	debugger := backend.NewDebugger(func(stop backend.Stop) backend.StepMode {
+		stops = append(stops, fmt.Sprintf("%s %d", filepath.Base(stop.Position.File), stop.Position.Line))
+		if stack == nil {
+			stack = stop.Stack
+		}
+		return backend.Continue
+	})
+	// line 2 of the module, not of the program
+	debugger.SetBreakpoint(module, 2, true)
+
+	rt := backend.NewRuntime()
+	rt.Dir, rt.File = dir, filepath.Join(dir, "main.mph")
+	rt.Hook = debugger
+	if err := exec.RunWith(rt, "import \"shapes.mph\" as shapes;\na = 1;\nb = shapes.twice(a);"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if fmt.Sprint(stops) != "[shapes.mph 2 shapes.mph 2]" {
+		t.Fatalf("expected to stop twice in shapes.mph, got %v", stops)
+	}
+	if len(stack) != 2 || stack[0].Function != "grow" || stack[0].File != module || stack[0].Line != 4 {
+		t.Fatalf("expected grow called from line 4 of shapes.mph, got %v", stack)
+	}
+}
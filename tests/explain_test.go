@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"io"
+	"strings"
+	"testing"
+)
+
+const explainProgram = `a = Box("a");
+b = Box("b");
+c = Box("c");
+g = Group([a, b] : [
+    *b is right of *a
+]);
+why = explain(b);`
+
+func explainRuntime(t *testing.T) backend.Runtime {
+	rt := backend.NewRuntime()
+	rt.Stdout = io.Discard
+	if err := exec.RunWith(rt, explainProgram); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return rt
+}
+
+func TestConstraintTrace(t *testing.T) {
+	rt := explainRuntime(t)
+
+	var found bool
+	for _, c := range rt.Constraints.Constraints {
+		if c.Relation != "is right of" {
+			continue
+		}
+		found = true
+		if c.Line != 5 || strings.Join(c.Items, " ") != "b a" {
+			t.Fatalf("expected b is right of a on line 5, got %s", c)
+		}
+		if c.Expr() != "b.x - a.x - a.w >= 0" {
+			t.Fatalf("unexpected expression %q", c.Expr())
+		}
+	}
+	if !found {
+		t.Fatalf("expected the is right of constraint to be traced, got %v", rt.Constraints.Constraints)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	rt := explainRuntime(t)
+
+	relations := map[string]bool{}
+	for _, c := range rt.Constraints.Explain(rt.SymbolTable["b"].(backend.LayoutItem)) {
+		relations[c.Relation] = true
+		for _, item := range c.Items {
+			if item == "c" {
+				t.Fatalf("expected nothing about c in b's explanation, got %s", c)
+			}
+		}
+	}
+	for _, relation := range []string{"width", "height", "is right of"} {
+		if !relations[relation] {
+			t.Fatalf("expected %q in b's explanation, got %v", relation, relations)
+		}
+	}
+
+	why := rt.SymbolTable["why"].(backend.StringData).Value
+	if !strings.HasPrefix(why, "b x=50 y=0 w=50 h=50") || !strings.Contains(why, "line 5: b, a is right of") {
+		t.Fatalf("unexpected explanation\n%s", why)
+	}
+}
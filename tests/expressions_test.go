@@ -4,6 +4,7 @@ import (
 	"github.com/adam-bunce/morpheus/backend"
 	exec "github.com/adam-bunce/morpheus/execute"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -62,6 +63,18 @@ func TestArithmeticExpr(t *testing.T) {
 		{`x = -10 / -2;`, "x", backend.IntData{Value: 5}},
 		{`x = -1 * (1 + 1);`, "x", backend.IntData{Value: -2}},
 		{`a = 2; x = a - 2;`, "x", backend.IntData{Value: 0}},
+		{`x = 1 + 2 * 3;`, "x", backend.IntData{Value: 7}},
+		{`x = (1 + 2) * 3;`, "x", backend.IntData{Value: 9}},
+		{`x = 10 - 2 - 3;`, "x", backend.IntData{Value: 5}},
+		{`x = 10-3;`, "x", backend.IntData{Value: 7}},
+		{`x = 7 % 3;`, "x", backend.IntData{Value: 1}},
+		{`x = 2 ** 3 ** 2;`, "x", backend.IntData{Value: 512}},
+		{`x = -2 ** 2;`, "x", backend.IntData{Value: -4}},
+		{`x = 2 * 3 ** 2;`, "x", backend.IntData{Value: 18}},
+		{`x = 3 ** 13;`, "x", backend.IntData{Value: 1594323}},
+		{`x = -1 ** 20000000001;`, "x", backend.IntData{Value: -1}},
+		{`a = 3; x = -a;`, "x", backend.IntData{Value: -3}},
+		{`a = 3; x = -(a + 1) * 2;`, "x", backend.IntData{Value: -8}},
 	}
 
 	for i, test := range table {
@@ -73,6 +86,23 @@ func TestArithmeticExpr(t *testing.T) {
 	}
 }
 
+func TestArithmeticErrors(t *testing.T) {
+	programs := []string{
+		`x = 1 / 0;`,
+		`zero = 0; x = 5 % zero;`,
+		`x = 2 ** -1;`,
+		`x = "a" * 2;`,
+	}
+
+	for i, program := range programs {
+		_, err := exec.Run(program)
+
+		if _, ok := err.(backend.RuntimeError); !ok {
+			t.Fatalf("[test %d] expected a RuntimeError, got %v", i+1, err)
+		}
+	}
+}
+
 func TestCompareExpr(t *testing.T) {
 	table := []struct {
 		program  string
@@ -90,6 +120,19 @@ func TestCompareExpr(t *testing.T) {
 		{`x = (false or false)`, "x", backend.BooleanData{Value: false}},
 		{`x = (false and true)`, "x", backend.BooleanData{Value: false}},
 		{`x = (true and true)`, "x", backend.BooleanData{Value: true}},
+		{`x = 5 != 2;`, "x", backend.BooleanData{Value: true}},
+		{`x = 5 <= 5;`, "x", backend.BooleanData{Value: true}},
+		{`x = 4 >= 5;`, "x", backend.BooleanData{Value: false}},
+		{`x = "a" != "a";`, "x", backend.BooleanData{Value: false}},
+		{`x = true == (1 < 2);`, "x", backend.BooleanData{Value: true}},
+		{`x = 1 == "1";`, "x", backend.BooleanData{Value: false}},
+		{`x = not true;`, "x", backend.BooleanData{Value: false}},
+		{`x = !(1 > 2);`, "x", backend.BooleanData{Value: true}},
+		{`x = not 1 > 2 and true;`, "x", backend.BooleanData{Value: true}},
+		{`x = true or false and false;`, "x", backend.BooleanData{Value: true}},
+		{`x = 1 + 1 == 2;`, "x", backend.BooleanData{Value: true}},
+		{`x = false and undefined;`, "x", backend.BooleanData{Value: false}},
+		{`x = true or undefined;`, "x", backend.BooleanData{Value: true}},
 	}
 
 	for i, test := range table {
@@ -177,6 +220,7 @@ func TestDeclareExpr(t *testing.T) {
 							Expr: backend.NewIntLiteral("5"),
 						},
 					},
+					Positions: []backend.Position{{Line: 2, Column: 10}},
 				},
 			},
 		},
@@ -434,3 +478,438 @@ length = value.len
 		}
 	}
 }
+
+func TestMapExpr(t *testing.T) {
+	size := backend.NewMapData().
+		Set("w", backend.IntData{Value: 100}).
+		Set("h", backend.IntData{Value: 40})
+
+	tests := []struct {
+		program  string
+		name     string
+		expected backend.Data
+	}{
+		{
+			program:  `size = {"w": 100, "h": 40};`,
+			name:     "size",
+			expected: size,
+		},
+		{
+			program:  `empty = {};`,
+			name:     "empty",
+			expected: backend.NewMapData(),
+		},
+		{
+			program:  `size = {"w": 100, "h": 40}; w = size.get("w");`,
+			name:     "w",
+			expected: backend.IntData{Value: 100},
+		},
+		{
+			program:  `size = {"w": 100}; size.set("h", 40);`,
+			name:     "size",
+			expected: size,
+		},
+		{
+			program:  `size = {"w": 1, "h": 40}; size.set("w", 100);`,
+			name:     "size",
+			expected: size,
+		},
+		{
+			program:  `size = {"w": 100, "d": 3, "h": 40}; size.del("d");`,
+			name:     "size",
+			expected: size,
+		},
+		{
+			program:  `size = {"w": 100}; has = size.has("w");`,
+			name:     "has",
+			expected: backend.BooleanData{Value: true},
+		},
+		{
+			program:  `size = {"w": 100}; has = size.has("h");`,
+			name:     "has",
+			expected: backend.BooleanData{Value: false},
+		},
+		{
+			program: `keys = {"w": 100, "h": 40}.keys;`,
+			name:    "keys",
+			expected: backend.ListData{Values: []backend.Data{
+				backend.StringData{Value: "w"},
+				backend.StringData{Value: "h"},
+			}},
+		},
+		{
+			program:  `length = {"w": 100, "h": 40}.len;`,
+			name:     "length",
+			expected: backend.IntData{Value: 2},
+		},
+		{
+			program:  `same = {"w": 100, "h": 40} == {"h": 40, "w": 100};`,
+			name:     "same",
+			expected: backend.BooleanData{Value: true},
+		},
+		{
+			program:  `same = {"w": 100} != {"w": 99};`,
+			name:     "same",
+			expected: backend.BooleanData{Value: true},
+		},
+		{
+			program:  `same = [1, [2, 3]] == [1, [2, 3]];`,
+			name:     "same",
+			expected: backend.BooleanData{Value: true},
+		},
+		{
+			program: `
+size = {"w": 100, "h": 40};
+total = 0;
+for key in size {
+	total = total + size.get(key);
+}
+`,
+			name:     "total",
+			expected: backend.IntData{Value: 140},
+		},
+		{
+			program: `
+total = 0;
+for value in [1, 2, 3] {
+	total = total + value;
+}
+`,
+			name:     "total",
+			expected: backend.IntData{Value: 6},
+		},
+	}
+
+	for i, test := range tests {
+		rt := exec.RunProgram(test.program)
+
+		if !backend.Equal(rt.SymbolTable[test.name], test.expected) {
+			t.Fatalf("[test %d] actual %v didn't match expected %v", i+1, rt.SymbolTable[test.name], test.expected)
+		}
+	}
+}
+
+func TestRecordExpr(t *testing.T) {
+	card := backend.RecordData{
+		Type:   "Card",
+		Fields: []string{"title", "width"},
+		Values: map[string]backend.Data{
+			"title": backend.StringData{Value: "hello"},
+			"width": backend.IntData{Value: 100},
+		},
+	}
+
+	tests := []struct {
+		program  string
+		name     string
+		expected backend.Data
+	}{
+		{
+			program:  `record Card { title, width } card = Card("hello", 100);`,
+			name:     "card",
+			expected: card,
+		},
+		{
+			program:  `record Card { title: string, width: int } card = Card("hello", 50 * 2);`,
+			name:     "card",
+			expected: card,
+		},
+		{
+			program:  `record Card { title, width } w = Card("hello", 100).width;`,
+			name:     "w",
+			expected: backend.IntData{Value: 100},
+		},
+		{
+			program: `
+record Size { w, h }
+record Card { title, size }
+
+function area(card) {
+	card.size.w * card.size.h
+}
+
+a = area(Card("c", Size(10, 20)));
+`,
+			name:     "a",
+			expected: backend.IntData{Value: 200},
+		},
+		{
+			program:  `record Card { title, width } same = Card("a", 1) == Card("a", 1);`,
+			name:     "same",
+			expected: backend.BooleanData{Value: true},
+		},
+	}
+
+	for i, test := range tests {
+		rt := exec.RunProgram(test.program)
+
+		if !backend.Equal(rt.SymbolTable[test.name], test.expected) {
+			t.Fatalf("[test %d] actual %v didn't match expected %v", i+1, rt.SymbolTable[test.name], test.expected)
+		}
+	}
+
+	errors := []string{
+		`record Card { title, width } card = Card("hello");`,
+		`record Card { title, width } h = Card("hello", 1).height;`,
+		`x = 5; y = x.width;`,
+	}
+
+	for i, program := range errors {
+		if _, err := exec.Run(program); err == nil {
+			t.Fatalf("[error test %d] expected a runtime error", i+1)
+		}
+	}
+}
+
+func TestListMethods(t *testing.T) {
+	list := func(values ...int) backend.ListData {
+		var ld backend.ListData
+		for _, v := range values {
+			ld.Values = append(ld.Values, backend.IntData{Value: v})
+		}
+		return ld
+	}
+
+	tests := []struct {
+		program  string
+		name     string
+		expected backend.Data
+	}{
+		{`length = [1,2,3].del(2).add(3).add(4).len;`, "length", backend.IntData{Value: 4}},
+		{`function make() { [3, 1, 2] } x = make().sort().get(0);`, "x", backend.IntData{Value: 1}},
+		{`x = [[1, 2], [3]].get(0).len;`, "x", backend.IntData{Value: 2}},
+		{`x = [1, 3].insert(1, 2);`, "x", list(1, 2, 3)},
+		{`x = [1, 2].insert(2, 3);`, "x", list(1, 2, 3)},
+		{`x = [1, 0, 3].set(1, 2);`, "x", list(1, 2, 3)},
+		{`x = [0, 1, 2, 3, 4].slice(1, 4);`, "x", list(1, 2, 3)},
+		{`x = [3, 2, 1].reverse();`, "x", list(1, 2, 3)},
+		{`x = [2, 3, 1].sort();`, "x", list(1, 2, 3)},
+		{`function desc(a, b) { a > b } x = [2, 3, 1].sort(desc);`, "x", list(3, 2, 1)},
+		{`x = [1, 2, 3].contains(2);`, "x", backend.BooleanData{Value: true}},
+		{`x = [1, 2, 3].contains(5);`, "x", backend.BooleanData{Value: false}},
+		{`x = [1, 2, 3].index_of(3);`, "x", backend.IntData{Value: 2}},
+		{`x = [1, 2, 3].index_of(5);`, "x", backend.IntData{Value: -1}},
+		{`x = [1].concat([2, 3]);`, "x", list(1, 2, 3)},
+		{`function double(n) { n * 2 } x = [1, 2, 3].map(double);`, "x", list(2, 4, 6)},
+		{`function odd(n) { n % 2 == 1 } x = [1, 2, 3].filter(odd);`, "x", list(1, 3)},
+		{`function add(acc, n) { acc + n } x = [1, 2, 3].reduce(add, 10);`, "x", backend.IntData{Value: 16}},
+		{`x = [1, 2]; x.insert(0, 0); x.set(2, 5);`, "x", list(0, 1, 5)},
+		{`x = {"a": [1, 2]}.get("a").add(3);`, "x", list(1, 2, 3)},
+	}
+
+	for i, test := range tests {
+		rt := exec.RunProgram(test.program)
+
+		if !backend.Equal(rt.SymbolTable[test.name], test.expected) {
+			t.Fatalf("[test %d] actual %v didn't match expected %v", i+1, rt.SymbolTable[test.name], test.expected)
+		}
+	}
+}
+
+func TestMethodArity(t *testing.T) {
+	tests := []struct {
+		program  string
+		expected string
+	}{
+		{`x = [1].add();`, "method add expects 1 args got 0"},
+		{`x = [2, 1].sort(1, 2);`, "method sort expects 0 to 1 args got 2"},
+	}
+
+	for i, test := range tests {
+		_, err := exec.Run(test.program)
+
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Fatalf("[test %d] expected %q, got %v", i+1, test.expected, err)
+		}
+	}
+}
+
+func TestIndexExpr(t *testing.T) {
+	tests := []struct {
+		program  string
+		name     string
+		expected backend.Data
+	}{
+		{`list = [1, 2, 3]; x = list[0];`, "x", backend.IntData{Value: 1}},
+		{`list = [1, 2, 3]; x = list[-1];`, "x", backend.IntData{Value: 3}},
+		{`list = [1, 2, 3]; x = list.get(-2);`, "x", backend.IntData{Value: 2}},
+		{`x = [[1, 2], [3, 4]][1][0];`, "x", backend.IntData{Value: 3}},
+		{`list = [1, 2, 3]; list[1] = 5;`, "list", backend.ListData{Values: []backend.Data{
+			backend.IntData{Value: 1}, backend.IntData{Value: 5}, backend.IntData{Value: 3},
+		}}},
+		{`grid = [[1, 2], [3, 4]]; grid[0][-1] = 9; x = grid[0];`, "x", backend.ListData{Values: []backend.Data{
+			backend.IntData{Value: 1}, backend.IntData{Value: 9},
+		}}},
+		{`list = [1, 2, 3, 4]; x = list[1:3];`, "x", backend.ListData{Values: []backend.Data{
+			backend.IntData{Value: 2}, backend.IntData{Value: 3},
+		}}},
+		{`list = [1, 2, 3, 4]; x = list[-1:];`, "x", backend.ListData{Values: []backend.Data{
+			backend.IntData{Value: 4},
+		}}},
+		{`list = [1, 2, 3, 4]; x = list[:0];`, "x", backend.ListData{}},
+		{`size = {"w": 1}; size["h"] = 2; x = size["h"];`, "x", backend.IntData{Value: 2}},
+		{`s = "hello"; x = s[0];`, "x", backend.StringData{Value: "h"}},
+		{`s = "hello"; x = s[-1];`, "x", backend.StringData{Value: "o"}},
+		{`s = "hello"; x = s[1:-1];`, "x", backend.StringData{Value: "ell"}},
+		{`s = "hello"; x = s[:2];`, "x", backend.StringData{Value: "he"}},
+	}
+
+	for i, test := range tests {
+		rt := exec.RunProgram(test.program)
+
+		if !backend.Equal(rt.SymbolTable[test.name], test.expected) {
+			t.Fatalf("[test %d] actual %v didn't match expected %v", i+1, rt.SymbolTable[test.name], test.expected)
+		}
+	}
+
+	errors := []string{
+		`list = [1, 2, 3]; x = list[3];`,
+		`list = [1, 2, 3]; x = list[-4];`,
+		`list = [1, 2, 3]; x = list.get(3);`,
+		`list = []; x = list[0];`,
+		`list = [1, 2, 3]; list[3] = 1;`,
+		`list = [1, 2, 3]; x = list[2:1];`,
+		`s = "hi"; x = s[2];`,
+		`s = "hi"; s[0] = "a";`,
+		`x = 5[0];`,
+	}
+
+	for i, program := range errors {
+		if _, err := exec.Run(program); err == nil {
+			t.Fatalf("[error test %d] expected a runtime error", i+1)
+		}
+	}
+}
+
+func TestMutationModel(t *testing.T) {
+	list := func(values ...int) backend.ListData {
+		var ld backend.ListData
+		for _, v := range values {
+			ld.Values = append(ld.Values, backend.IntData{Value: v})
+		}
+		return ld
+	}
+
+	tests := []struct {
+		program  string
+		name     string
+		expected backend.Data
+	}{
+		// aliases are copies
+		{`a = [1]; b = a; b.add(2);`, "a", list(1)},
+		{`a = [1]; b = a; b.add(2);`, "b", list(1, 2)},
+		{`a = [1]; b = a; b[0] = 5;`, "a", list(1)},
+		{`a = {"x": 1}; b = a; b.set("x", 2); x = a["x"];`, "x", backend.IntData{Value: 1}},
+		// function arguments are copies
+		{`function grow(l) { l.add(3); l } a = [1]; b = grow(a);`, "a", list(1)},
+		{`function grow(l) { l.add(3); l } a = [1]; b = grow(a);`, "b", list(1, 3)},
+		// methods on non-variables return the changed copy
+		{`function make() { [1] } x = make().add(2);`, "x", list(1, 2)},
+		{`x = [1, 2].del(0).insert(0, 0).set(1, 5);`, "x", list(0, 5)},
+		// nested places are updated
+		{`grid = [[1], [2]]; grid[1].add(3); x = grid[1];`, "x", list(2, 3)},
+		{`record Card { tags } c = Card([]); c.tags.add(1); x = c.tags;`, "x", list(1)},
+		{`record Card { w } c = Card(1); c.w = 2; x = c.w;`, "x", backend.IntData{Value: 2}},
+		{`record Card { w } cards = [Card(1)]; cards[0].w = 2; x = cards[0].w;`, "x", backend.IntData{Value: 2}},
+		{`record Card { w } a = Card(1); b = a; b.w = 2; x = a.w;`, "x", backend.IntData{Value: 1}},
+		// positions are only worked out once, the change goes back where it came from
+		{`n = 0; function next() { n = n + 1; n - 1 } grid = [[1], [2]]; grid[next()].add(3); x = grid[0];`, "x", list(1, 3)},
+		{`n = 0; function next() { n = n + 1; n - 1 } grid = [[1], [2]]; grid[next()].add(3);`, "n", backend.IntData{Value: 1}},
+		{`n = 0; function next() { n = n + 1; n - 1 } grid = [[1], [2]]; grid[next()][0] = 5; x = grid[0];`, "x", list(5)},
+		{`record Card { w } n = 0; function next() { n = n + 1; n - 1 } cards = [Card(1), Card(2)]; cards[next()].w = 5; x = cards[0].w;`, "x", backend.IntData{Value: 5}},
+	}
+
+	for i, test := range tests {
+		rt := exec.RunProgram(test.program)
+
+		if !backend.Equal(rt.SymbolTable[test.name], test.expected) {
+			t.Fatalf("[test %d] actual %v didn't match expected %v", i+1, rt.SymbolTable[test.name], test.expected)
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	str := func(s string) backend.Data { return backend.StringData{Value: s} }
+	num := func(i int) backend.Data { return backend.IntData{Value: i} }
+
+	tests := []struct {
+		program  string
+		name     string
+		expected backend.Data
+	}{
+		{`x = len("héllo");`, "x", num(5)},
+		{`x = len([1, 2]);`, "x", num(2)},
+		{`x = substr("hello", 1, 3);`, "x", str("el")},
+		{`x = substr("hello", 1, -1);`, "x", str("ell")},
+		{`x = split("a,b", ",");`, "x", backend.ListData{Values: []backend.Data{str("a"), str("b")}}},
+		{`x = join(["a", "b", 1], ", ");`, "x", str("a, b, 1")},
+		{`x = upper("hi");`, "x", str("HI")},
+		{`x = lower("Hi");`, "x", str("hi")},
+		{`x = trim("  hi ");`, "x", str("hi")},
+		{`x = contains("hello", "ell");`, "x", backend.BooleanData{Value: true}},
+		{`x = replace("a-b-c", "-", "+");`, "x", str("a+b+c")},
+		{`x = repeat("ab", 3);`, "x", str("ababab")},
+		{`x = int("42") + 1;`, "x", num(43)},
+		{`x = str(42) ++ "px";`, "x", str("42px")},
+		{`x = str([1, "a"]);`, "x", str(`[1, "a"]`)},
+		{`w = 10; h = 20; x = format("{} x {}", w, h);`, "x", str("10 x 20")},
+		{`x = ["ab", "c"].map(len);`, "x", backend.ListData{Values: []backend.Data{num(2), num(1)}}},
+		// variables shadow builtins
+		{`function upper(s) { s } x = upper("hi");`, "x", str("hi")},
+	}
+
+	for i, test := range tests {
+		rt := exec.RunProgram(test.program)
+
+		if !backend.Equal(rt.SymbolTable[test.name], test.expected) {
+			t.Fatalf("[test %d] actual %v didn't match expected %v", i+1, rt.SymbolTable[test.name], test.expected)
+		}
+	}
+
+	errors := []string{
+		`x = int("abc");`,
+		`x = upper(1);`,
+		`x = substr("abc", 0, 10);`,
+		`x = format("{} {}", 1);`,
+		`x = format("{}", 1, 2);`,
+		`x = len("a", "b");`,
+		`x = repeat("ab", 2 ** 62);`,
+		`x = repeat("ab", 100000000);`,
+	}
+
+	for i, program := range errors {
+		_, err := exec.Run(program)
+
+		if _, ok := err.(backend.RuntimeError); !ok {
+			t.Fatalf("[error %d] expected a RuntimeError, got %v", i+1, err)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		program  string
+		expected string
+	}{
+		{`print(5);`, "5\n"},
+		{`print("hi");`, "hi\n"},
+		{`print(true, -1);`, "true -1\n"},
+		{`print([1, "a", [true]]);`, "[1, \"a\", [true]]\n"},
+		{`print({"w": 100, "h": 40});`, "{\"w\": 100, \"h\": 40}\n"},
+		{`record Card { title, width } print(Card("hi", 10));`, "Card{title: \"hi\", width: 10}\n"},
+		{`w = 10; print("w", w); print();`, "w 10\n\n"},
+	}
+
+	for i, test := range tests {
+		var out strings.Builder
+		rt := backend.NewRuntime()
+		rt.Stdout = &out
+
+		if err := exec.RunWith(rt, test.program); err != nil {
+			t.Fatalf("[test %d] unexpected error %v", i+1, err)
+		}
+		if out.String() != test.expected {
+			t.Fatalf("[test %d] printed %q expected %q", i+1, out.String(), test.expected)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+// goldenRoots maps directories of programs to where their golden files live,
+// testdata programs keep theirs alongside them
+var goldenRoots = map[string]string{
+	"testdata":    "testdata",
+	"../examples": filepath.Join("testdata", "examples"),
+}
+
+// TestGolden runs every .mph program and compares what it printed, the
+// variables it ended with, its layouts and any files it rendered against
+// <name>.golden, run `go test ./tests -run TestGolden -update` to rewrite them
+func TestGolden(t *testing.T) {
+	for root, goldenDir := range goldenRoots {
+		programs, err := findPrograms(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, program := range programs {
+			rel, _ := filepath.Rel(root, program)
+			golden := filepath.Join(goldenDir, strings.TrimSuffix(rel, ".mph")+".golden")
+
+			t.Run(filepath.ToSlash(filepath.Join(root, rel)), func(t *testing.T) {
+				actual := runGolden(t, program, exec.RunWith)
+
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, []byte(actual), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				expected, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("missing golden file, run with -update to create it: %v", err)
+				}
+				if actual != string(expected) {
+					t.Fatalf("output didn't match %s\n--- actual ---\n%s\n--- expected ---\n%s", golden, actual, expected)
+				}
+
+				// the vm has to behave exactly like the interpreter
+				compiled := runGolden(t, program, exec.RunCompiled)
+				if compiled != string(expected) {
+					t.Fatalf("compiled output didn't match %s\n--- actual ---\n%s\n--- expected ---\n%s", golden, compiled, expected)
+				}
+			})
+		}
+	}
+}
+
+func findPrograms(root string) ([]string, error) {
+	var programs []string
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// programs only imported by others aren't run on their own
+		if entry.IsDir() && entry.Name() == "modules" {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() && filepath.Ext(path) == ".mph" {
+			programs = append(programs, path)
+		}
+		return nil
+	})
+
+	return programs, err
+}
+
+// runGolden runs program with run and renders everything it did as golden file text
+func runGolden(t *testing.T, program string, run func(backend.Runtime, string) error) string {
+	source, err := os.ReadFile(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout strings.Builder
+	sink := backend.NewMemorySink()
+
+	rt := backend.NewRuntime()
+	rt.Dir = filepath.Dir(program)
+	rt.Stdout = &stdout
+	rt.Output = sink
+
+	var sb strings.Builder
+	if err := run(rt, string(source)); err != nil {
+		sb.WriteString(fmt.Sprintf("-- error --\n%s\n", err))
+	}
+
+	sb.WriteString("-- stdout --\n")
+	sb.WriteString(stdout.String())
+
+	var names []string
+	for name := range rt.SymbolTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sb.WriteString("-- variables --\n")
+	for _, name := range names {
+		if _, ok := rt.SymbolTable[name].(backend.LayoutItem); !ok {
+			sb.WriteString(fmt.Sprintf("%s = %s\n", name, backend.Format(rt.SymbolTable[name])))
+		}
+	}
+
+	for _, name := range names {
+		if item, ok := rt.SymbolTable[name].(backend.LayoutItem); ok {
+			layout, err := json.MarshalIndent(layoutOf(item), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sb.WriteString(fmt.Sprintf("-- layout %s --\n%s\n", name, layout))
+		}
+	}
+
+	for _, name := range sink.Names() {
+		file, _ := sink.File(name)
+		sb.WriteString(fmt.Sprintf("-- file %s --\n%s\n", name, file))
+	}
+
+	return sb.String()
+}
+
+type goldenLayout struct {
+	Id       string         `json:"id,omitempty"`
+	X        float64        `json:"x"`
+	Y        float64        `json:"y"`
+	W        float64        `json:"w"`
+	H        float64        `json:"h"`
+	Children []goldenLayout `json:"children,omitempty"`
+}
+
+func layoutOf(item backend.LayoutItem) goldenLayout {
+	round := func(v float64) float64 {
+		// + 0 turns -0 into 0
+		return math.Round(v*100)/100 + 0
+	}
+
+	layout := goldenLayout{
+		X: round(item.LeftEdge()),
+		Y: round(item.Top()),
+		W: round(item.RightEdge() - item.LeftEdge()),
+		H: round(item.Bottom() - item.Top()),
+	}
+
+	var children []backend.LayoutItem
+	switch item := item.(type) {
+	case backend.Span:
+		return layoutOf(item.LayoutItem)
+	case backend.Box:
+		layout.Id = item.Id
+	case backend.Group:
+		children = item.Items
+	case backend.Stack:
+		children = item.Items
+	case backend.Grid:
+		children = item.Items()
+	}
+	for _, child := range children {
+		layout.Children = append(layout.Children, layoutOf(child))
+	}
+
+	return layout
+}
@@ -1,9 +1,11 @@
 package tests
 
 import (
+	"fmt"
 	"github.com/adam-bunce/morpheus/backend"
 	exec "github.com/adam-bunce/morpheus/execute"
 	"github.com/lithdew/casso"
+	"strings"
 	"testing"
 )
 
@@ -148,3 +150,191 @@ super_group.htmlify("test")
 
 	exec.RunProgram(program)
 }
+
+func TestHStack(t *testing.T) {
+	s := casso.NewSolver()
+
+	a := backend.NewBox(s, "a")
+	b := backend.NewBox(s, "b")
+	c := backend.NewBox(s, "c")
+
+	stack := backend.NewStack(s, true, 10, "", a, b, c)
+
+	if s.Val(a.CX) != s.Val(stack.X) || s.Val(b.CX)-s.Val(a.CX) != 60 || s.Val(c.CX)-s.Val(b.CX) != 60 {
+		t.Fatalf("expected a, b, c 10 apart from the stack's left, got %s", stack)
+	}
+	if s.Val(stack.W) != 170 || s.Val(stack.H) != 50 {
+		t.Fatalf("expected the stack to be 170x50, got %s", stack)
+	}
+	for _, box := range []backend.Box{a, b, c} {
+		if s.Val(box.CY) != s.Val(stack.Y) {
+			t.Fatalf("expected %s to be aligned to the stack's top, got %s", box.Id, stack)
+		}
+	}
+}
+
+func TestVStackAlignment(t *testing.T) {
+	for alignment, offset := range map[string]float64{"left": 0, "center": 25, "right": 50} {
+		s := casso.NewSolver()
+
+		row := backend.NewStack(s, true, 0, "", backend.NewBox(s, "a"), backend.NewBox(s, "b"))
+		c := backend.NewBox(s, "c")
+		stack := backend.NewStack(s, false, 5, alignment, row, c)
+
+		if s.Val(stack.W) != 100 || s.Val(stack.H) != 105 {
+			t.Fatalf("expected the %s stack to be 100x105, got %s", alignment, stack)
+		}
+		if s.Val(c.CX)-s.Val(stack.X) != offset {
+			t.Fatalf("expected c %g from the %s stack's left, got %s", offset, alignment, stack)
+		}
+		if s.Val(c.CY)-s.Val(stack.Y) != 55 {
+			t.Fatalf("expected c 5 below the row, got %s", stack)
+		}
+	}
+}
+
+func TestStackCreation(t *testing.T) {
+	program := `
+function toolbar(n) {
+	buttons = [];
+	for i in (0, n, 1) {
+		buttons.add(Box("button"));
+	}
+	HStack(buttons, 8, "center")
+}
+
+page = VStack([toolbar(4), Box("body")], 16);
+side = VStack([]);
+page.htmlify("stack");
+`
+
+	rt := backend.NewRuntime()
+	rt.Output = backend.NewMemorySink()
+	if err := exec.RunWith(rt, program); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	page := rt.SymbolTable["page"].(backend.Stack)
+	if page.RightEdge()-page.LeftEdge() != 4*50+3*8 || page.Bottom()-page.Top() != 50+16+50 {
+		t.Fatalf("expected the page to be 224x116, got %s", page)
+	}
+
+	side := rt.SymbolTable["side"].(backend.Stack)
+	if side.RightEdge() != side.LeftEdge() || side.Bottom() != side.Top() {
+		t.Fatalf("expected an empty stack to have no size, got %s", side)
+	}
+}
+
+func TestStackBadAlignment(t *testing.T) {
+	rt := backend.NewRuntime()
+	err := exec.RunWith(rt, `s = HStack([Box("a")], 0, "left");`)
+	if err == nil || !strings.Contains(err.Error(), "HStack alignment must be top, center, bottom") {
+		t.Fatalf("expected an alignment error, got %v", err)
+	}
+}
+
+func TestGrid(t *testing.T) {
+	s := casso.NewSolver()
+
+	var boxes []backend.LayoutItem
+	for i := 0; i < 5; i++ {
+		boxes = append(boxes, backend.NewBox(s, fmt.Sprint(i)))
+	}
+	grid := backend.NewGrid(s, 3, 10, boxes...)
+
+	if grid.Rows != 2 || s.Val(grid.W) != 170 || s.Val(grid.H) != 110 {
+		t.Fatalf("expected 2 rows 170x110, got %s", grid)
+	}
+	for i, x := range []float64{0, 60, 120} {
+		if s.Val(grid.ColumnX[i])-s.Val(grid.X) != x || s.Val(grid.ColumnW[i]) != 50 {
+			t.Fatalf("expected column %d at %g 50 wide, got %s", i, x, grid)
+		}
+	}
+	fourth := boxes[3]
+	if fourth.LeftEdge() != grid.LeftEdge() || fourth.Top()-grid.Top() != 60 {
+		t.Fatalf("expected the fourth box to start the second row, got %s", grid)
+	}
+}
+
+func TestGridSpans(t *testing.T) {
+	s := casso.NewSolver()
+
+	wide := backend.NewStack(s, true, 0, "", backend.NewBox(s, "a"), backend.NewBox(s, "b"), backend.NewBox(s, "c"))
+	tall := backend.NewStack(s, false, 0, "", backend.NewBox(s, "d"), backend.NewBox(s, "e"), backend.NewBox(s, "h"))
+	f, g := backend.NewBox(s, "f"), backend.NewBox(s, "g")
+
+	// wide takes the first two columns, tall the last column of both rows and
+	// f and g fill the gap left under wide
+	grid := backend.NewGrid(s, 3, 10,
+		backend.Span{LayoutItem: wide, Columns: 2, Rows: 1},
+		backend.Span{LayoutItem: tall, Columns: 1, Rows: 2},
+		f, g)
+
+	var placed []string
+	for _, cell := range grid.Cells {
+		placed = append(placed, fmt.Sprintf("%d,%d %dx%d", cell.Column, cell.Row, cell.Columns, cell.Rows))
+	}
+	if strings.Join(placed, " ") != "0,0 2x1 2,0 1x2 0,1 1x1 1,1 1x1" {
+		t.Fatalf("unexpected placement %v", placed)
+	}
+
+	// the first two columns and the gap between them are as wide as wide
+	if s.Val(grid.ColumnX[2])-s.Val(grid.ColumnX[0]) != 160 {
+		t.Fatalf("expected the third column 160 from the first, got %s", grid)
+	}
+	// and the two rows as tall as tall
+	if s.Val(grid.RowH[0])+s.Val(grid.RowH[1]) != 140 || s.Val(grid.H) != 150 {
+		t.Fatalf("expected the rows to add up to 140 and the grid to be 150 tall, got %s", grid)
+	}
+	if f.LeftEdge() != grid.LeftEdge() || g.LeftEdge() != s.Val(grid.ColumnX[1]) || f.Top() != g.Top() {
+		t.Fatalf("expected f and g side by side under wide, got %s", grid)
+	}
+}
+
+func TestGridCreation(t *testing.T) {
+	program := `
+header = HStack([Box("logo"), Box("title")]);
+cards = [span(header, 2, 1)];
+for i in (0, 4, 1) {
+	cards.add(Box("card"));
+}
+
+dashboard = Grid(cards, 2, 10);
+why = explain(cards[1]);
+`
+
+	rt := backend.NewRuntime()
+	if err := exec.RunWith(rt, program); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	dashboard := rt.SymbolTable["dashboard"].(backend.Grid)
+	if dashboard.Rows != 3 || dashboard.RightEdge()-dashboard.LeftEdge() != 110 || dashboard.Bottom()-dashboard.Top() != 170 {
+		t.Fatalf("expected a 110x170 grid with 3 rows, got %s", dashboard)
+	}
+
+	why := rt.SymbolTable["why"].(backend.StringData).Value
+	if !strings.Contains(why, "card, grid1 is in column 0 of") || !strings.Contains(why, "grid1.row1.y") {
+		t.Fatalf("expected the first card's explanation to use its column and row, got\n%s", why)
+	}
+}
+
+func TestGridBadSpan(t *testing.T) {
+	tests := []struct {
+		program  string
+		expected string
+	}{
+		{`g = Grid([span(Box("a"), 3, 1)], 2);`, "spans 3 columns but the grid only has 2"},
+		// sizes are capped before anything is allocated for them
+		{`g = Grid([Box("a")], 1000000000);`, "Grid needs 1 to 1000 columns got 1000000000"},
+		{`g = Grid([span(Box("a"), 1, 1000000000)], 1);`, "spans 1 to 1000 columns and rows got 1x1000000000"},
+		{`g = Grid([span(Box("a"), 1, 1000), Box("b")], 1);`, "Grid can't have more than 1000 rows"},
+	}
+
+	for i, test := range tests {
+		err := exec.RunWith(backend.NewRuntime(), test.program)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Fatalf("[test %d] expected %q, got %v", i+1, test.expected, err)
+		}
+	}
+}
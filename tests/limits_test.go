@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	forever := `for i in (0, 1000000000, 1) { x = i; }`
+
+	tests := []struct {
+		program  string
+		limits   backend.Limits
+		expected error
+	}{
+		{forever, backend.Limits{MaxSteps: 1000}, backend.ErrStepLimit},
+		{forever, backend.Limits{Timeout: 10 * time.Millisecond}, backend.ErrTimeout},
+		{`function f(n) { 1 + f(n + 1) } f(0);`, backend.Limits{MaxCallDepth: 50}, backend.ErrCallDepth},
+		{`l = []; for i in (0, 100, 1) { l.add(i); }`, backend.Limits{MaxListSize: 10}, backend.ErrListSize},
+		{`l = [1, 2, 3];`, backend.Limits{MaxListSize: 2}, backend.ErrListSize},
+		{`l = [1].concat([2, 3]);`, backend.Limits{MaxListSize: 2}, backend.ErrListSize},
+		{`l = split(repeat(",", 1000), ",");`, backend.Limits{MaxListSize: 100}, backend.ErrListSize},
+		{`s = repeat("a", 1000);`, backend.Limits{MaxStringSize: 100}, backend.ErrStringSize},
+		{`s = "a"; for i in (0, 100, 1) { s = s ++ s; }`, backend.Limits{MaxStringSize: 1000}, backend.ErrStringSize},
+		// strings are capped even without limits
+		{`s = "a"; for i in (0, 100, 1) { s = s ++ s; }`, backend.Limits{}, backend.ErrStringSize},
+		// under the limits runs normally
+		{`function f(n) { n } l = [f(1), f(2)];`, backend.Limits{MaxSteps: 100, MaxCallDepth: 2, MaxListSize: 2}, nil},
+	}
+
+	for i, test := range tests {
+		err := exec.RunContext(context.Background(), backend.NewRuntime(), test.program, test.limits)
+
+		if test.expected == nil && err != nil {
+			t.Fatalf("[test %d] unexpected error %v", i+1, err)
+		}
+		if !errors.Is(err, test.expected) {
+			t.Fatalf("[test %d] expected %v, got %v", i+1, test.expected, err)
+		}
+	}
+}
+
+func TestLimitsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := exec.RunContext(ctx, backend.NewRuntime(), `for i in (0, 1000000000, 1) { x = i; }`, backend.Limits{})
+	if !errors.Is(err, backend.ErrCanceled) {
+		t.Fatalf("expected %v, got %v", backend.ErrCanceled, err)
+	}
+	if _, ok := err.(backend.RuntimeError); !ok {
+		t.Fatalf("expected a RuntimeError, got %T", err)
+	}
+}
+
+// TestScriptTypeErrors checks badly typed scripts come back as errors instead
+// of crashing whoever is running them
+func TestScriptTypeErrors(t *testing.T) {
+	programs := []string{
+		`for i in (0, "x", 1) { }`,
+		`for i in ("a", 2, 1) { }`,
+		`for i in (0, 2, [1]) { }`,
+		`g = Group([1, 2] : []);`,
+		`a = 1; b = Box("b"); g = Group([b] : [*a is left of *b]);`,
+		`g = 1; g.htmlify("out");`,
+	}
+
+	for i, program := range programs {
+		for name, run := range map[string]func(backend.Runtime, string) error{"interpreter": exec.RunWith, "vm": exec.RunCompiled} {
+			rt := backend.NewRuntime()
+			rt.Output = backend.NewMemorySink()
+			err := run(rt, program)
+
+			if _, ok := err.(backend.RuntimeError); !ok {
+				t.Fatalf("[test %d] expected a RuntimeError from the %s, got %v", i+1, name, err)
+			}
+		}
+	}
+}
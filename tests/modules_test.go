@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles creates files (path -> source) under a temp dir and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestImport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"components/cards.mph": `
+			width = 100;
+			function pad(n) { n + 10 }
+			function card_width(n) { pad(n) + width }
+		`,
+		"components/counter.mph": `tick();`,
+		"lib/theme.mph":          `primary = "#0055ff";`,
+	})
+
+	tests := []struct {
+		program  string
+		name     string
+		expected backend.Data
+	}{
+		{`import "components/cards.mph" as cards; x = cards.width;`, "x", backend.IntData{Value: 100}},
+		// module functions see the rest of their module
+		{`import "components/cards.mph" as cards; x = cards.card_width(5);`, "x", backend.IntData{Value: 115}},
+		// and not the importer's variables
+		{`width = 1; import "components/cards.mph" as cards; x = cards.card_width(0);`, "x", backend.IntData{Value: 110}},
+		// non relative imports fall back to the search path
+		{`import "theme.mph" as theme; x = theme.primary;`, "x", backend.StringData{Value: "#0055ff"}},
+	}
+
+	for i, test := range tests {
+		rt := backend.NewRuntime()
+		rt.Dir = dir
+		rt.Modules.SearchPath = []string{filepath.Join(dir, "lib")}
+
+		if err := exec.RunWith(rt, test.program); err != nil {
+			t.Fatalf("[test %d] unexpected error %v", i+1, err)
+		}
+		if !backend.Equal(rt.SymbolTable[test.name], test.expected) {
+			t.Fatalf("[test %d] actual %v didn't match expected %v", i+1, rt.SymbolTable[test.name], test.expected)
+		}
+	}
+
+	// modules are only evaluated once per runtime
+	rt := backend.NewRuntime()
+	rt.Dir = dir
+	ticks := 0
+	rt.Register("tick", 0, func(args []backend.Data) (backend.Data, error) {
+		ticks++
+		return backend.NoData{}, nil
+	})
+	program := `
+		import "components/counter.mph" as a;
+		import "./components/counter.mph" as b;
+	`
+	if err := exec.RunWith(rt, program); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if ticks != 1 {
+		t.Fatalf("expected module to run once, ran %d times", ticks)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.mph":     `import "b.mph" as b;`,
+		"b.mph":     `import "./a.mph" as a;`,
+		"lib/x.mph": `x = 1;`,
+	})
+
+	programs := []string{
+		`import "a.mph" as a;`,
+		`import "missing.mph" as m;`,
+		`import "lib/x.mph" as x; y = x.missing;`,
+		// relative imports don't use the search path
+		`import "./x.mph" as x;`,
+	}
+
+	for i, program := range programs {
+		rt := backend.NewRuntime()
+		rt.Dir = dir
+		rt.Modules.SearchPath = []string{filepath.Join(dir, "lib")}
+
+		if _, ok := exec.RunWith(rt, program).(backend.RuntimeError); !ok {
+			t.Fatalf("[test %d] expected a RuntimeError", i+1)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHtmlifyMemorySink(t *testing.T) {
+	sink := backend.NewMemorySink()
+	rt := backend.NewRuntime()
+	rt.Output = sink
+
+	err := exec.RunWith(rt, `a = Box("a"); b = Box("b"); g = Group([a, b] : [*a is left of *b]); g.htmlify("page");`)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	html, ok := sink.File("page.html")
+	if !ok {
+		t.Fatalf("expected page.html to be written, got %v", sink.Names())
+	}
+	if !strings.Contains(string(html), "<html") {
+		t.Fatalf("expected html output, got %s", html)
+	}
+}
+
+func TestDirSink(t *testing.T) {
+	dir := t.TempDir()
+	sink := backend.DirSink{Dir: filepath.Join(dir, "out")}
+
+	if err := sink.WriteFile("pages/page.html", []byte("hi")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "out", "pages", "page.html")); err != nil || string(data) != "hi" {
+		t.Fatalf("expected file to be written, got %q %v", data, err)
+	}
+
+	for _, name := range []string{"../escape.html", "pages/../../escape.html", "/tmp/escape.html", ""} {
+		if err := sink.WriteFile(name, []byte("hi")); err == nil {
+			t.Fatalf("expected writing %q to fail", name)
+		}
+	}
+
+	rt := backend.NewRuntime()
+	rt.Output = sink
+	err := exec.RunWith(rt, `a = Box("a"); a.htmlify("../escape");`)
+	if _, ok := err.(backend.RuntimeError); !ok {
+		t.Fatalf("expected a RuntimeError, got %v", err)
+	}
+}
+
+func TestDirSinkSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{outside, out} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(out, "link")); err != nil {
+		t.Skipf("can't make symlinks here: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "file.html"), filepath.Join(out, "file.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := backend.DirSink{Dir: out}
+	for _, name := range []string{"link/escape.html", "link/new/escape.html", "file.html"} {
+		if err := sink.WriteFile(name, []byte("hi")); err == nil {
+			t.Fatalf("expected writing %q to fail", name)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Fatalf("expected nothing written outside the output directory, got %v", entries)
+	}
+
+	// a symlink to the output directory itself is fine
+	linked := filepath.Join(dir, "linked")
+	if err := os.Symlink(out, linked); err != nil {
+		t.Fatal(err)
+	}
+	if err := (backend.DirSink{Dir: linked}).WriteFile("page.html", []byte("hi")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestDefaultOutput(t *testing.T) {
+	if sink, ok := backend.NewRuntime().Output.(backend.DirSink); !ok || sink.Dir != backend.DefaultOutputDir {
+		t.Fatalf("expected the default output to be a DirSink in %s, got %v", backend.DefaultOutputDir, backend.NewRuntime().Output)
+	}
+}
@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
+	"github.com/lithdew/casso"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const profileProgram = `function sq(n) {
+    n * n
+}
+total = 0;
+for i in (0, 10, 1) {
+    total = total + sq(i);
+}
+a = Box("a");
+b = Box("b");
+g = Group([a, b] : [*b is right of *a]);
+print(len("abc"));`
+
+func TestProfiler(t *testing.T) {
+	profiler := backend.NewProfiler()
+	rt := backend.NewRuntime()
+	rt.Hook = profiler
+	rt.Stdout = io.Discard
+	if err := exec.RunWith(rt, profileProgram); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	calls := map[string]int{}
+	for name, fn := range profiler.Functions {
+		calls[name] = fn.Calls
+		if fn.Self > fn.Time {
+			t.Fatalf("%s has more self time (%s) than total time (%s)", name, fn.Self, fn.Time)
+		}
+	}
+	if calls["sq"] != 10 || calls["len"] != 1 {
+		t.Fatalf("expected sq to be called 10 times and len once, got %v", calls)
+	}
+
+	counts := map[int]int{}
+	for line, lp := range profiler.Lines {
+		counts[line.Line] = lp.Count
+	}
+	for line, expected := range map[int]int{2: 10, 5: 1, 6: 10, 8: 1} {
+		if counts[line] != expected {
+			t.Fatalf("expected line %d to run %d times, got %v", line, expected, counts)
+		}
+	}
+
+	// two boxes, the constraint and the group
+	if profiler.SolverCalls != 4 {
+		t.Fatalf("expected 4 solver calls, got %d", profiler.SolverCalls)
+	}
+
+	var report bytes.Buffer
+	profiler.Report(&report)
+	if !strings.Contains(report.String(), "sq") {
+		t.Fatalf("expected sq in the report, got\n%s", report.String())
+	}
+}
+
+// wrappedProfiler is a hook around a profiler, like one an embedder might
+// write to combine it with their own
+type wrappedProfiler struct {
+	profiler   *backend.Profiler
+	statements int
+}
+
+func (w *wrappedProfiler) Before(r backend.Runtime, e backend.Expression, pos backend.Position) {
+	w.statements++
+	w.profiler.Before(r, e, pos)
+}
+
+func (w *wrappedProfiler) After(r backend.Runtime, e backend.Expression, pos backend.Position, result backend.Data) {
+	w.profiler.After(r, e, pos, result)
+}
+
+func (w *wrappedProfiler) Solved(r backend.Runtime, line int, elapsed time.Duration) {
+	w.profiler.Solved(r, line, elapsed)
+}
+
+func TestProfilerWrapped(t *testing.T) {
+	hook := &wrappedProfiler{profiler: backend.NewProfiler()}
+	// a runtime made without NewRuntime has no call stack
+	rt := backend.Runtime{
+		SymbolTable: map[string]backend.Data{},
+		Solver:      casso.NewSolver(),
+		Natives:     backend.NewRuntime().Natives,
+		Stdout:      io.Discard,
+		Hook:        hook,
+	}
+	if err := exec.RunWith(rt, profileProgram); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	line := hook.profiler.Lines[backend.SourceLine{Line: 2}]
+	if hook.statements == 0 || line == nil || line.Count != 10 {
+		t.Fatalf("expected line 2 to run 10 times, got %v", line)
+	}
+	if hook.profiler.SolverCalls != 4 {
+		t.Fatalf("expected 4 solver calls through the wrapper, got %d", hook.profiler.SolverCalls)
+	}
+}
+
+func TestProfilerModules(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"shapes.mph": "function grow(n) {\n    n + 1\n}",
+	})
+	main, module := filepath.Join(dir, "main.mph"), filepath.Join(dir, "shapes.mph")
+
+	profiler := backend.NewProfiler()
+	rt := backend.NewRuntime()
+	rt.Dir, rt.File = dir, main
+	rt.Hook = profiler
+	if err := exec.RunWith(rt, "import \"shapes.mph\" as shapes;\na = shapes.grow(1);\nb = shapes.grow(a);"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// line 2 of each file is counted separately
+	for line, expected := range map[backend.SourceLine]int{{File: main, Line: 2}: 1, {File: module, Line: 2}: 2} {
+		if lp := profiler.Lines[line]; lp == nil || lp.Count != expected {
+			t.Fatalf("expected %s to run %d times, got %v", line, expected, lp)
+		}
+	}
+
+	var report bytes.Buffer
+	profiler.Report(&report)
+	if !strings.Contains(report.String(), module+":2") {
+		t.Fatalf("expected %s:2 in the report, got\n%s", module, report.String())
+	}
+
+	var out bytes.Buffer
+	if err := profiler.WritePprof(&out, main); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("expected a gzipped profile, got %v", err)
+	}
+	profile, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, s := range []string{main, module, "main.mph", "grow"} {
+		if !bytes.Contains(profile, []byte(s)) {
+			t.Fatalf("expected %q in the profile", s)
+		}
+	}
+}
+
+func TestProfilerCompiled(t *testing.T) {
+	profiler := backend.NewProfiler()
+	rt := backend.NewRuntime()
+	rt.Hook = profiler
+	rt.Stdout = io.Discard
+	if err := exec.RunCompiled(rt, profileProgram); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the vm only reports calls
+	if profiler.Functions["sq"] == nil || profiler.Functions["sq"].Calls != 10 {
+		t.Fatalf("expected sq to be called 10 times, got %v", profiler.Functions["sq"])
+	}
+	if len(profiler.Lines) != 0 {
+		t.Fatalf("expected no line profiles from the vm, got %d", len(profiler.Lines))
+	}
+}
+
+func TestProfilerPprof(t *testing.T) {
+	profiler := backend.NewProfiler()
+	rt := backend.NewRuntime()
+	rt.Hook = profiler
+	rt.Stdout = io.Discard
+	if err := exec.RunWith(rt, profileProgram); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := profiler.WritePprof(&out, "profile.mph"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("expected a gzipped profile, got %v", err)
+	}
+	profile, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the string table has the sample types, functions and file
+	for _, s := range []string{"statements", "nanoseconds", "sq", "profile.mph"} {
+		if !bytes.Contains(profile, []byte(s)) {
+			t.Fatalf("expected %q in the profile", s)
+		}
+	}
+}
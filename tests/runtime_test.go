@@ -1,7 +1,9 @@
 package tests
 
 import (
+	"errors"
 	"github.com/adam-bunce/morpheus/backend"
+	exec "github.com/adam-bunce/morpheus/execute"
 	"testing"
 )
 
@@ -92,3 +94,38 @@ func TestRuntimeSubscope(t *testing.T) {
 	}
 
 }
+
+func TestRuntimeRegister(t *testing.T) {
+	runtime := backend.NewRuntime()
+
+	tokens := map[string]string{"primary": "#0055ff"}
+	runtime.Register("token", 1, func(args []backend.Data) (backend.Data, error) {
+		name, ok := args[0].(backend.StringData)
+		if !ok {
+			return nil, errors.New("token name must be a string")
+		}
+		value, ok := tokens[name.Value]
+		if !ok {
+			return nil, errors.New("unknown token " + name.Value)
+		}
+		return backend.StringData{Value: value}, nil
+	})
+
+	err := exec.RunWith(runtime, `function color(name) { token(name) } x = color("primary");`)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if runtime.SymbolTable["x"].(backend.StringData).Value != "#0055ff" {
+		t.Fatalf("symbol table should contain `%s` with value of `%s` got: %v", "x", "#0055ff", runtime)
+	}
+
+	err = exec.RunWith(runtime, `x = token("missing");`)
+	if _, ok := err.(backend.RuntimeError); !ok {
+		t.Fatalf("expected a RuntimeError, got %v", err)
+	}
+
+	err = exec.RunWith(runtime, `x = token("a", "b");`)
+	if _, ok := err.(backend.RuntimeError); !ok {
+		t.Fatalf("expected a RuntimeError, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package tests
+
+import (
+	exec "github.com/adam-bunce/morpheus/execute"
+	"testing"
+)
+
+func TestTypeCheck(t *testing.T) {
+	table := []struct {
+		program string
+		errors  int
+	}{
+		{`x = 5 + 3;`, 0},
+		{`x = "a" + 1;`, 1},
+		{`x = "a" ++ "b"; y = x + 1;`, 1},
+		{`x = (5 < "a");`, 1},
+		{`x = (true and 1);`, 1},
+		{`for i in (0, "ten", 1) { print(i) }`, 1},
+		{`g = Group([1,2] : []);`, 1},
+		{`a = Box("a"); b = Box("b"); g = Group([a, b] : [*a is below *b]);`, 0},
+		{`a = 1; b = Box("b"); g = Group([b] : [*a is below *b]);`, 1},
+		{`function add(x: int, y: int) { x + y } z = add(1, 2);`, 0},
+		{`function add(x: int, y: int) { x + y } z = add("1", 2);`, 1},
+		{`function add(x, y) { x + y } z = add(1);`, 1},
+		{`function name() { "hi" } z = name() + 1;`, 1},
+		{`function f(x: float) { x }`, 1},
+		{`x = [1, 2, 3].get("a");`, 1},
+		{`x = 5; y = x.len;`, 1},
+		{`record Card { title: string, width: int } c = Card("a", 1); w = c.width + 1;`, 0},
+		{`record Card { title: string, width: int } c = Card(1, 1);`, 1},
+		{`record Card { title, width } c = Card("a", 1); h = c.height;`, 1},
+		{`record Card { title: string, width } c = Card("a", 1); w = c.title + 1;`, 1},
+		// a branch that might not run doesn't decide a variable's type
+		{`x = 1; if (true) { x = "a"; } y = x + 1;`, 0},
+		{`if (true) { x = "a"; } else { x = "b"; } y = x + 1;`, 1},
+		{`if (true) { x = "a"; } y = x + 1;`, 0},
+		{`for i in (0, 3, 1) { w = "a"; } y = w + 1;`, 0},
+		{`x = "a"; for i in (0, 3, 1) { x = "b"; } y = x + 1;`, 1},
+		// the loop iterator only shadows a variable while the loop runs
+		{`i = "a"; for i in (0, 3, 1) { } y = i + 1;`, 1},
+	}
+
+	for i, test := range table {
+		errs := exec.CheckProgram(test.program)
+
+		if len(errs) != test.errors {
+			t.Fatalf("[test %d] expected %d type errors, got %d: %v", i+1, test.errors, len(errs), errs)
+		}
+	}
+}